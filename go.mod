@@ -13,11 +13,15 @@ require (
 
 require github.com/prometheus/procfs v0.17.0
 
-require github.com/pkg/errors v0.9.1
+require (
+	github.com/klauspost/compress v1.18.0
+	github.com/pkg/errors v0.9.1
+	github.com/ulikunitz/xz v0.5.15
+)
 
 require (
 	github.com/Masterminds/semver/v3 v3.4.0
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	golang.org/x/sys v0.33.0 // indirect
+	golang.org/x/sys v0.33.0
 )
@@ -0,0 +1,238 @@
+package io
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	journalMagic                  = "harvester-copy-journal v1"
+	defaultCheckpointSyncInterval = 5 * time.Second
+	// resumeVerifyChunks is how many of the most recent journal entries
+	// are compared against the source before they are trusted. Those are
+	// the writes most likely to have been lost with the page cache.
+	resumeVerifyChunks = 8
+)
+
+// ResumeCopy continues a Copy that was started with opts.Checkpoint set and
+// got interrupted. It reads the journal, re-checks its last few entries and
+// copies only the ranges that are still missing. Without a journal it is a
+// plain CopyContext. The options must use the same ChunkSize as the original
+// copy.
+func ResumeCopy(ctx context.Context, src *os.File, dst *os.File, opts CopyOptions) (*Result, error) {
+	if opts.Checkpoint == "" {
+		return nil, fmt.Errorf("resuming a copy requires a checkpoint")
+	}
+	c, err := newFileCopier(src, dst, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := readJournal(opts.Checkpoint, c.size, c.opts.ChunkSize)
+	if errors.Is(err, os.ErrNotExist) {
+		return CopyContext(ctx, src, dst, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err = verifyJournalTail(src, dst, entries, c.opts.ChunkSize)
+	if err != nil {
+		return nil, err
+	}
+	done := mergeExtents(entries)
+	c.skipDone(done)
+	if c.verify != nil {
+		c.verify.resume(done, func(offset uint64, buf []byte) error {
+			_, err := PReadExact(src, buf, len(buf), offset)
+			return err
+		})
+	}
+	if c.journal, err = openJournal(opts.Checkpoint, dst); err != nil {
+		return nil, err
+	}
+	return c.run(ctx)
+}
+
+// verifyJournalTail drops the most recent chunk entries whose destination
+// content does not match the source.
+func verifyJournalTail(src, dst *os.File, entries []extent, chunkSize int) ([]extent, error) {
+	srcBuf := make([]byte, chunkSize)
+	dstBuf := make([]byte, chunkSize)
+	verified := slices.Clone(entries[:max(0, len(entries)-resumeVerifyChunks)])
+	for _, e := range entries[len(verified):] {
+		// larger entries are holes, which were never written
+		if e.length > uint64(chunkSize) {
+			verified = append(verified, e)
+			continue
+		}
+		count := int(e.length)
+		if _, err := PReadExact(src, srcBuf, count, e.offset); err != nil {
+			return nil, err
+		}
+		// a failed or short read means the destination is too short
+		if n, err := PReadExact(dst, dstBuf, count, e.offset); err != nil || n != count {
+			continue
+		}
+		if bytes.Equal(srcBuf[:count], dstBuf[:count]) {
+			verified = append(verified, e)
+		}
+	}
+	return verified, nil
+}
+
+// journal is an append-only text file: a header line with the copy size and
+// chunk size, then one "offset length" line per finished range.
+type journal struct {
+	path string
+	file *os.File
+	// dst is fdatasynced before recorded entries are written out, so that
+	// the journal never lists data that could still be lost
+	dst *os.File
+
+	mu sync.Mutex
+	// pending holds the entries recorded since the last sync
+	pending bytes.Buffer
+
+	// syncMu serializes syncs and guards err
+	syncMu sync.Mutex
+	// err is the first error hit while writing the journal
+	err error
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func createJournal(path string, dst *os.File, size uint64, chunkSize int) (*journal, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(file, "%s %d %d\n", journalMagic, size, chunkSize); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &journal{path: path, file: file, dst: dst}, nil
+}
+
+func openJournal(path string, dst *os.File) (*journal, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &journal{path: path, file: file, dst: dst}, nil
+}
+
+// readJournal returns the finished ranges recorded in the journal at path, in
+// the order they were recorded. A torn last line is ignored.
+func readJournal(path string, size uint64, chunkSize int) ([]extent, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(data), "\n")
+	if lines[0] != fmt.Sprintf("%s %d %d", journalMagic, size, chunkSize) {
+		return nil, fmt.Errorf("%w: unexpected header %q", ErrCheckpointMismatch, lines[0])
+	}
+
+	var entries []extent
+	// the last element is either empty or an incomplete line
+	for _, line := range lines[1 : len(lines)-1] {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%w: malformed entry %q", ErrCheckpointMismatch, line)
+		}
+		offset, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: malformed entry %q", ErrCheckpointMismatch, line)
+		}
+		length, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil || offset+length > size {
+			return nil, fmt.Errorf("%w: malformed entry %q", ErrCheckpointMismatch, line)
+		}
+		entries = append(entries, extent{offset: offset, length: length})
+	}
+	return entries, nil
+}
+
+func (j *journal) record(start, end uint64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	fmt.Fprintf(&j.pending, "%d %d\n", start, end-start)
+}
+
+// sync writes the recorded entries out and fsyncs the journal. The
+// destination is fdatasynced first: the entries were recorded after their
+// data was written, so it covers all of them.
+func (j *journal) sync() error {
+	j.syncMu.Lock()
+	defer j.syncMu.Unlock()
+	j.mu.Lock()
+	entries := bytes.Clone(j.pending.Bytes())
+	j.pending.Reset()
+	j.mu.Unlock()
+	if j.err != nil || len(entries) == 0 {
+		return j.err
+	}
+	if err := unix.Fdatasync(int(j.dst.Fd())); err != nil {
+		j.err = fmt.Errorf("error syncing %s: %w", j.dst.Name(), err)
+		return j.err
+	}
+	if _, err := j.file.Write(entries); err != nil {
+		j.err = err
+		return j.err
+	}
+	j.err = j.file.Sync()
+	return j.err
+}
+
+// start fsyncs the journal every interval until close is called.
+func (j *journal) start(interval time.Duration) {
+	if interval == 0 {
+		interval = defaultCheckpointSyncInterval
+	}
+	j.stop, j.stopped = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(j.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-j.stop:
+				return
+			case <-ticker.C:
+				_ = j.sync()
+			}
+		}
+	}()
+}
+
+func (j *journal) close() error {
+	if j.stop != nil {
+		close(j.stop)
+		<-j.stopped
+	}
+	err := j.sync()
+	if cerr := j.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (j *journal) remove() error {
+	return os.Remove(j.path)
+}
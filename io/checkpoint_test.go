@@ -0,0 +1,177 @@
+package io
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *IOTestSuite) TestCopyCheckpointRemovedOnSuccess() {
+	srcFile, data := suite.createSparseFile()
+	defer os.Remove(srcFile.Name())
+
+	// Create a temporary dstFile for testing
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+	suite.Require().NoError(dstFile.Truncate(int64(len(data))))
+
+	checkpoint := filepath.Join(suite.T().TempDir(), "journal")
+	_, err = CopyContext(context.Background(), srcFile, dstFile, CopyOptions{ChunkSize: 1024 * 1024, Checkpoint: checkpoint})
+	suite.Require().NoError(err)
+	assert.NoFileExists(suite.T(), checkpoint)
+
+	dstData := make([]byte, len(data))
+	_, err = dstFile.ReadAt(dstData, 0)
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), data, dstData)
+}
+
+func (suite *IOTestSuite) TestResumeCopyAfterFault() {
+	srcFile, data := suite.createRandomFile(5*1024*1024 + 777)
+	defer os.Remove(srcFile.Name())
+
+	// Create a temporary dstFile for testing
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	// fail half way through, once the chunks below have been journaled
+	opts := CopyOptions{ChunkSize: 4096, Checkpoint: filepath.Join(suite.T().TempDir(), "journal")}
+	opts.Faults = NewFaultPlan(Fault{Op: FaultWrite, Offset: 3 * 1024 * 1024, AtOffset: true})
+	res, err := CopyContext(context.Background(), srcFile, dstFile, opts)
+	assert.ErrorIs(suite.T(), err, ErrFaultInject)
+	assert.NotNil(suite.T(), res)
+	assert.FileExists(suite.T(), opts.Checkpoint)
+	entries, err := readJournal(opts.Checkpoint, uint64(len(data)), opts.ChunkSize)
+	suite.Require().NoError(err)
+	assert.NotEmpty(suite.T(), entries)
+
+	var resumed Progress
+	opts.Faults = nil
+	opts.Progress = func(p Progress) { resumed = p }
+	_, err = ResumeCopy(context.Background(), srcFile, dstFile, opts)
+	suite.Require().NoError(err)
+	assert.NoFileExists(suite.T(), opts.Checkpoint)
+	assert.NotZero(suite.T(), resumed.ResumedBytes)
+
+	dstData := make([]byte, len(data))
+	_, err = dstFile.ReadAt(dstData, 0)
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), data, dstData)
+}
+
+func (suite *IOTestSuite) TestResumeCopyFromJournal() {
+	srcFile, data := suite.createRandomFile(1024 * 1024)
+	defer os.Remove(srcFile.Name())
+
+	// The first half was copied, but the last journaled chunk got lost
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+	_, err = dstFile.WriteAt(data[:512*1024-4096], 0)
+	suite.Require().NoError(err)
+
+	journal := fmt.Sprintf("%s %d %d\n", journalMagic, len(data), 4096)
+	for offset := 0; offset < 512*1024; offset += 4096 {
+		journal += fmt.Sprintf("%d %d\n", offset, 4096)
+	}
+	// a torn entry from the crash
+	journal += "52428"
+	checkpoint := filepath.Join(suite.T().TempDir(), "journal")
+	suite.Require().NoError(os.WriteFile(checkpoint, []byte(journal), 0600))
+
+	var last Progress
+	opts := CopyOptions{ChunkSize: 4096, Checkpoint: checkpoint, Progress: func(p Progress) { last = p }}
+	_, err = ResumeCopy(context.Background(), srcFile, dstFile, opts)
+	suite.Require().NoError(err)
+
+	// Only the lost chunk and the second half were copied again
+	assert.Equal(suite.T(), uint64(512*1024+4096), last.BytesRead)
+	assert.Equal(suite.T(), uint64(512*1024-4096), last.ResumedBytes)
+	assert.Equal(suite.T(), float64(100), last.Percent())
+
+	dstData := make([]byte, len(data))
+	_, err = dstFile.ReadAt(dstData, 0)
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), data, dstData)
+}
+
+func (suite *IOTestSuite) TestResumeCopyJournalMismatch() {
+	// Create a temporary srcFile for testing
+	srcFile, err := os.CreateTemp("", "4K_file")
+	suite.Require().NoError(err)
+	defer os.Remove(srcFile.Name())
+	_, err = srcFile.WriteAt(make([]byte, 4096), 0)
+	suite.Require().NoError(err)
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	checkpoint := filepath.Join(suite.T().TempDir(), "journal")
+	suite.Require().NoError(os.WriteFile(checkpoint, []byte(journalMagic+" 8192 4096\n"), 0600))
+	_, err = ResumeCopy(context.Background(), srcFile, dstFile, CopyOptions{ChunkSize: 4096, Checkpoint: checkpoint})
+	assert.ErrorIs(suite.T(), err, ErrCheckpointMismatch)
+}
+
+func (suite *IOTestSuite) TestCommitTrackerOverlap() {
+	t := &commitTracker{}
+	t.commit(8192, 12288)
+	t.commit(4096, 16384)
+	assert.Equal(suite.T(), uint64(0), t.committed())
+	t.commit(0, 4096)
+	assert.Equal(suite.T(), uint64(16384), t.committed())
+	t.commit(0, 8192)
+	assert.Equal(suite.T(), uint64(16384), t.committed())
+}
+
+func (suite *IOTestSuite) TestResumeCopyVerify() {
+	srcFile, data := suite.createRandomFile(1024 * 1024)
+	defer os.Remove(srcFile.Name())
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	opts := CopyOptions{ChunkSize: 4096, Readers: 1, Writers: 1, Checkpoint: filepath.Join(suite.T().TempDir(), "journal")}
+	opts.Faults = NewFaultPlan(Fault{Op: FaultWrite, Offset: 512 * 1024, AtOffset: true})
+	_, err = CopyContext(context.Background(), srcFile, dstFile, opts)
+	suite.Require().ErrorIs(err, ErrFaultInject)
+
+	opts.Faults = nil
+	opts.Verify = true
+	res, err := ResumeCopy(context.Background(), srcFile, dstFile, opts)
+	suite.Require().NoError(err)
+	assert.NotZero(suite.T(), res.BytesRead)
+	assert.Less(suite.T(), res.BytesRead, uint64(len(data)))
+	assert.Empty(suite.T(), res.Mismatches)
+	assert.NotEmpty(suite.T(), res.Digest)
+
+	// a resumed chunk that got lost is caught
+	suite.Require().NoError(os.WriteFile(opts.Checkpoint, []byte(fmt.Sprintf("%s %d %d\n0 %d\n", journalMagic, len(data), 4096, 64*1024)), 0600))
+	_, err = dstFile.WriteAt(make([]byte, 4096), 8192)
+	suite.Require().NoError(err)
+	res, err = ResumeCopy(context.Background(), srcFile, dstFile, opts)
+	assert.ErrorIs(suite.T(), err, ErrVerifyMismatch)
+	assert.Equal(suite.T(), []uint64{8192}, res.Mismatches)
+}
+
+func (suite *IOTestSuite) TestJournalSyncsDestinationFirst() {
+	// fdatasync fails with EINVAL on /dev/null
+	dst, err := os.OpenFile("/dev/null", os.O_WRONLY, 0)
+	suite.Require().NoError(err)
+	defer dst.Close()
+
+	path := filepath.Join(suite.T().TempDir(), "journal")
+	j, err := createJournal(path, dst, 8192, 4096)
+	suite.Require().NoError(err)
+	j.record(0, 4096)
+	assert.ErrorContains(suite.T(), j.close(), "error syncing")
+
+	// the entry never reached the journal
+	entries, err := readJournal(path, 8192, 4096)
+	suite.Require().NoError(err)
+	assert.Empty(suite.T(), entries)
+}
@@ -0,0 +1,113 @@
+package io
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	stdio "io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Compression is a compressed image format recognised by its magic bytes.
+type Compression int
+
+const (
+	// CompressionNone is a raw image.
+	CompressionNone Compression = iota
+	CompressionGzip
+	CompressionZstd
+	CompressionXz
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionGzip:
+		return "gzip"
+	case CompressionZstd:
+		return "zstd"
+	case CompressionXz:
+		return "xz"
+	}
+	return "unknown"
+}
+
+var compressionMagics = []struct {
+	compression Compression
+	magic       []byte
+}{
+	{CompressionGzip, []byte{0x1f, 0x8b}},
+	{CompressionZstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{CompressionXz, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+}
+
+// detectCompression peeks at the start of r without consuming it.
+func detectCompression(r *bufio.Reader) (Compression, error) {
+	head, err := r.Peek(6)
+	if err != nil && err != stdio.EOF {
+		return CompressionNone, err
+	}
+	for _, m := range compressionMagics {
+		if bytes.HasPrefix(head, m.magic) {
+			return m.compression, nil
+		}
+	}
+	return CompressionNone, nil
+}
+
+// NewDecompressor detects the compression of r by its magic bytes and
+// returns a reader of the decompressed data. Raw images are passed through
+// unchanged. Closing the reader does not close r.
+func NewDecompressor(r stdio.Reader) (stdio.ReadCloser, Compression, error) {
+	br := bufio.NewReaderSize(r, 1024*1024)
+	compression, err := detectCompression(br)
+	if err != nil {
+		return nil, CompressionNone, err
+	}
+
+	switch compression {
+	case CompressionGzip:
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, compression, err
+		}
+		return zr, compression, nil
+	case CompressionZstd:
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, compression, err
+		}
+		return zr.IOReadCloser(), compression, nil
+	case CompressionXz:
+		xr, err := xz.NewReader(br)
+		if err != nil {
+			return nil, compression, err
+		}
+		return stdio.NopCloser(xr), compression, nil
+	}
+	return stdio.NopCloser(br), compression, nil
+}
+
+// WriteDecompressed writes the image in r to dst, decompressing it on the
+// fly when it is gzip, zstd or xz compressed, so that compressed images need
+// no temporary copy. The decompressed stream goes through WriteFromReader
+// and follows its zero policy and progress semantics. Result.Compression
+// reports the detected format.
+func WriteDecompressed(ctx context.Context, dst *os.File, r stdio.Reader, opts CopyOptions) (*Result, error) {
+	dr, compression, err := NewDecompressor(r)
+	if err != nil {
+		return nil, err
+	}
+	defer dr.Close()
+
+	res, err := WriteFromReader(ctx, dst, dr, opts)
+	if res != nil {
+		res.Compression = compression
+	}
+	return res, err
+}
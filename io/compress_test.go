@@ -0,0 +1,82 @@
+package io
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	stdio "io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/ulikunitz/xz"
+)
+
+func (suite *IOTestSuite) TestWriteDecompressed() {
+	data := make([]byte, 2*1024*1024+555)
+	_, err := rand.Read(data)
+	suite.Require().NoError(err)
+	clear(data[512*1024 : 1536*1024])
+
+	for compression, newWriter := range map[Compression]func(stdio.Writer) (stdio.WriteCloser, error){
+		CompressionNone: func(w stdio.Writer) (stdio.WriteCloser, error) {
+			return nopWriteCloser{w}, nil
+		},
+		CompressionGzip: func(w stdio.Writer) (stdio.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		},
+		CompressionZstd: func(w stdio.Writer) (stdio.WriteCloser, error) {
+			return zstd.NewWriter(w)
+		},
+		CompressionXz: func(w stdio.Writer) (stdio.WriteCloser, error) {
+			return xz.NewWriter(w)
+		},
+	} {
+		srcFile, err := os.CreateTemp("", "compressed_file")
+		suite.Require().NoError(err)
+		defer os.Remove(srcFile.Name())
+		w, err := newWriter(srcFile)
+		suite.Require().NoError(err)
+		_, err = w.Write(data)
+		suite.Require().NoError(err)
+		suite.Require().NoError(w.Close())
+		_, err = srcFile.Seek(0, stdio.SeekStart)
+		suite.Require().NoError(err)
+
+		dstFile, err := os.CreateTemp("", "dstfile")
+		suite.Require().NoError(err)
+		defer os.Remove(dstFile.Name())
+
+		res, err := WriteDecompressed(context.Background(), dstFile, srcFile, CopyOptions{ChunkSize: 256 * 1024, ZeroPolicy: ZeroWrite})
+		suite.Require().NoError(err, compression.String())
+		assert.Equal(suite.T(), compression, res.Compression)
+
+		dstData, err := os.ReadFile(dstFile.Name())
+		suite.Require().NoError(err)
+		assert.Equal(suite.T(), data, dstData, compression.String())
+	}
+}
+
+func (suite *IOTestSuite) TestWriteDecompressedCorrupt() {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write(make([]byte, 1024*1024))
+	suite.Require().NoError(err)
+	suite.Require().NoError(w.Close())
+	// cut the stream short
+	truncated := buf.Bytes()[:buf.Len()/2]
+
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	_, err = WriteDecompressed(context.Background(), dstFile, bytes.NewReader(truncated), CopyOptions{})
+	assert.Error(suite.T(), err)
+}
+
+type nopWriteCloser struct {
+	stdio.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
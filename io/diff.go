@@ -0,0 +1,59 @@
+package io
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"sync/atomic"
+)
+
+// DiffCopy makes dst identical to src like CopyContext, but compares every
+// chunk with what the destination already holds and only writes the ones that
+// differ. Re-syncing a nearly identical device this way costs reads instead
+// of writes. Zero regions of the source are zeroed on the destination, so a
+// ZeroSkip policy is upgraded to ZeroWrite.
+func DiffCopy(ctx context.Context, src *os.File, dst *os.File, opts CopyOptions) (*Result, error) {
+	if opts.ZeroPolicy == ZeroSkip {
+		opts.ZeroPolicy = ZeroWrite
+	}
+	c, err := newFileCopier(src, dst, opts)
+	if err != nil {
+		return nil, err
+	}
+	dstSize, err := getSourceVolSize(dst)
+	if err != nil {
+		return nil, err
+	}
+	c.diff = &differ{dst: dst, dstSize: dstSize}
+	return startCopy(ctx, c)
+}
+
+// differ reads destination chunks back for DiffCopy. The workers read the
+// destination while the producers read the source, so both sides are read
+// in parallel.
+type differ struct {
+	dst     *os.File
+	dstSize uint64
+
+	changedChunks   atomic.Uint64
+	unchangedChunks atomic.Uint64
+}
+
+// unchanged reports whether the destination already holds obj. buf is a
+// chunk-sized scratch buffer owned by the calling worker.
+func (d *differ) unchanged(obj Content, buf []byte) (bool, error) {
+	count := len(obj.buf)
+	if obj.offset+uint64(count) > d.dstSize {
+		d.changedChunks.Add(1)
+		return false, nil
+	}
+	if _, err := PReadExact(d.dst, buf, count, obj.offset); err != nil {
+		return false, err
+	}
+	if bytes.Equal(buf[:count], obj.buf) {
+		d.unchangedChunks.Add(1)
+		return true, nil
+	}
+	d.changedChunks.Add(1)
+	return false, nil
+}
@@ -0,0 +1,64 @@
+package io
+
+import (
+	"context"
+	"os"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *IOTestSuite) TestDiffCopy() {
+	srcFile, data := suite.createRandomFile(1024 * 1024)
+	defer os.Remove(srcFile.Name())
+
+	// with a zero chunk
+	clear(data[64*1024 : 128*1024])
+	_, err := srcFile.WriteAt(data, 0)
+	suite.Require().NoError(err)
+
+	// The destination differs in two data chunks and in the zero chunk
+	stale := make([]byte, len(data))
+	copy(stale, data)
+	stale[10] ^= 0xff
+	stale[512*1024+100] ^= 0xff
+	stale[64*1024+1] = 1
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+	_, err = dstFile.WriteAt(stale, 0)
+	suite.Require().NoError(err)
+
+	var last Progress
+	opts := CopyOptions{ChunkSize: 64 * 1024, Progress: func(p Progress) { last = p }}
+	res, err := DiffCopy(context.Background(), srcFile, dstFile, opts)
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), uint64(3), res.ChunksChanged)
+	assert.Equal(suite.T(), uint64(13), res.ChunksUnchanged)
+	assert.Equal(suite.T(), uint64(3*64*1024), last.BytesWritten)
+
+	dstData := make([]byte, len(data))
+	_, err = dstFile.ReadAt(dstData, 0)
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), data, dstData)
+}
+
+func (suite *IOTestSuite) TestDiffCopyShortDestination() {
+	srcFile, data := suite.createRandomFile(1024*1024 + 777)
+	defer os.Remove(srcFile.Name())
+
+	// Only the first half is on the destination yet
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+	_, err = dstFile.WriteAt(data[:512*1024], 0)
+	suite.Require().NoError(err)
+
+	res, err := DiffCopy(context.Background(), srcFile, dstFile, CopyOptions{ChunkSize: 256 * 1024})
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), uint64(3), res.ChunksChanged)
+	assert.Equal(suite.T(), uint64(2), res.ChunksUnchanged)
+
+	dstData, err := os.ReadFile(dstFile.Name())
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), data, dstData)
+}
@@ -0,0 +1,176 @@
+package io
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// DirectFile is a file opened by OpenDirect, along with the block sizes its
+// I/O has to be aligned to. The embedded *os.File is what Copy and Write take.
+type DirectFile struct {
+	*os.File
+	// Direct is false when the filesystem rejected O_DIRECT and the file
+	// was opened for buffered I/O instead.
+	Direct bool
+	// LogicalBlockSize is the smallest unit the device can address. Offsets
+	// and lengths of O_DIRECT I/O must be multiples of it.
+	LogicalBlockSize int
+	// PhysicalBlockSize is the unit the device writes internally. I/O
+	// aligned to it avoids read-modify-write cycles.
+	PhysicalBlockSize int
+}
+
+// OpenDirect opens path with O_DIRECT added to flags and discovers its block
+// sizes, with BLKSSZGET/BLKPBSZGET for block devices and statfs for files. On
+// filesystems that reject O_DIRECT, such as some tmpfs, it falls back to
+// buffered I/O; Close then fdatasyncs the file, so that data written through
+// the handle is durable either way.
+func OpenDirect(path string, flags int) (*DirectFile, error) {
+	file, err := os.OpenFile(path, flags|unix.O_DIRECT, 0644)
+	direct := err == nil
+	if errors.Is(err, unix.EINVAL) {
+		file, err = os.OpenFile(path, flags, 0644)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	logical, physical, err := blockSizes(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &DirectFile{
+		File:              file,
+		Direct:            direct,
+		LogicalBlockSize:  logical,
+		PhysicalBlockSize: physical,
+	}, nil
+}
+
+// Close closes the file, fdatasyncing it first when it fell back to buffered
+// I/O and was opened for writing.
+func (f *DirectFile) Close() error {
+	if !f.Direct {
+		if flags, err := unix.FcntlInt(f.Fd(), unix.F_GETFL, 0); err == nil && flags&unix.O_ACCMODE != unix.O_RDONLY {
+			if err := unix.Fdatasync(int(f.Fd())); err != nil {
+				f.File.Close()
+				return err
+			}
+		}
+	}
+	return f.File.Close()
+}
+
+// isBlockDevice reports whether info describes a block device. Character
+// devices have os.ModeDevice set as well.
+func isBlockDevice(info os.FileInfo) bool {
+	return info.Mode()&os.ModeDevice != 0 && info.Mode()&os.ModeCharDevice == 0
+}
+
+// blockSizes returns the logical and physical block size of f.
+func blockSizes(f *os.File) (logical, physical int, err error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	if isBlockDevice(info) {
+		var lbs int32
+		var pbs uint32
+		if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), unix.BLKSSZGET, uintptr(unsafe.Pointer(&lbs))); errno != 0 {
+			return 0, 0, fmt.Errorf("error getting logical block size: %v", errno)
+		}
+		if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), unix.BLKPBSZGET, uintptr(unsafe.Pointer(&pbs))); errno != 0 {
+			return 0, 0, fmt.Errorf("error getting physical block size: %v", errno)
+		}
+		return int(lbs), int(pbs), nil
+	}
+
+	var st unix.Statfs_t
+	if err := unix.Fstatfs(int(f.Fd()), &st); err != nil {
+		return 0, 0, err
+	}
+	return int(st.Bsize), int(st.Bsize), nil
+}
+
+// directBlockSize returns the logical block size of f when it is open with
+// O_DIRECT, and 0 otherwise.
+func directBlockSize(f *os.File) (int, error) {
+	flags, err := unix.FcntlInt(f.Fd(), unix.F_GETFL, 0)
+	if err != nil {
+		return 0, err
+	}
+	if flags&unix.O_DIRECT == 0 {
+		return 0, nil
+	}
+	logical, _, err := blockSizes(f)
+	return logical, err
+}
+
+// checkDirectIO makes sure that chunks line up with the logical block size of
+// every file opened with O_DIRECT, so that only the final chunk may have an
+// unaligned length.
+func checkDirectIO(opts CopyOptions, files ...*os.File) error {
+	for _, f := range files {
+		blockSize, err := directBlockSize(f)
+		if err != nil {
+			return err
+		}
+		if blockSize != 0 && opts.Alignment%blockSize != 0 {
+			return fmt.Errorf("%w: alignment %d is not a multiple of the %d byte logical block size of %s",
+				ErrInvalidOptions, opts.Alignment, blockSize, f.Name())
+		}
+	}
+	return nil
+}
+
+// unalignedDirect reports whether an I/O of count bytes on f cannot be issued
+// as is because f is open with O_DIRECT and count is not a multiple of its
+// logical block size. That only happens for the tail of an image, so the
+// extra fcntl is skipped for page-sized I/O.
+func unalignedDirect(f *os.File, count int) (int, bool) {
+	if count%baseAlignSize == 0 {
+		return 0, false
+	}
+	blockSize, err := directBlockSize(f)
+	if err != nil || blockSize == 0 {
+		return 0, false
+	}
+	return blockSize, count%blockSize != 0
+}
+
+// preadDirectTail reads an unaligned tail from an O_DIRECT file by reading
+// whole blocks into an aligned buffer; the read comes back short at the end
+// of the file.
+func preadDirectTail(src *os.File, buf []byte, count int, offset uint64, blockSize int) (int, error) {
+	rounded := (count + blockSize - 1) / blockSize * blockSize
+	aligned, err := allocAligned(rounded)
+	if err != nil {
+		return 0, err
+	}
+	defer freeAligned(aligned)
+	n, err := PReadExact(src, aligned, rounded, offset)
+	if err != nil {
+		return 0, err
+	}
+	return copy(buf[:count], aligned[:n]), nil
+}
+
+// pwriteDirectTail writes an unaligned tail to an O_DIRECT file through a
+// second, buffered handle on the same file, and fdatasyncs it to match the
+// durability of direct writes.
+func pwriteDirectTail(dst *os.File, data []byte, offset uint64) error {
+	buffered, err := os.OpenFile(fmt.Sprintf("/proc/self/fd/%d", dst.Fd()), os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer buffered.Close()
+	if _, err := buffered.WriteAt(data, int64(offset)); err != nil {
+		return err
+	}
+	return unix.Fdatasync(int(buffered.Fd()))
+}
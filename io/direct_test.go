@@ -0,0 +1,62 @@
+package io
+
+import (
+	"context"
+	"os"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *IOTestSuite) TestOpenDirect() {
+	// an unaligned size makes the last chunk bypass O_DIRECT
+	srcFile, data := suite.createRandomFile(1024*1024 + 123)
+	defer os.Remove(srcFile.Name())
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	src, err := OpenDirect(srcFile.Name(), os.O_RDONLY)
+	suite.Require().NoError(err)
+	defer src.Close()
+	dst, err := OpenDirect(dstFile.Name(), os.O_RDWR)
+	suite.Require().NoError(err)
+	if !src.Direct || !dst.Direct {
+		suite.T().Skip("O_DIRECT is not supported on the temp directory")
+	}
+	assert.Greater(suite.T(), dst.LogicalBlockSize, 0)
+	assert.GreaterOrEqual(suite.T(), dst.PhysicalBlockSize, dst.LogicalBlockSize)
+
+	_, err = CopyContext(context.Background(), src.File, dst.File, CopyOptions{ChunkSize: 64 * 1024})
+	suite.Require().NoError(err)
+	suite.Require().NoError(dst.Close())
+
+	dstData, err := os.ReadFile(dstFile.Name())
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), data, dstData, "Data should be the same")
+}
+
+func (suite *IOTestSuite) TestOpenDirectAlignment() {
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	dst, err := OpenDirect(dstFile.Name(), os.O_RDWR)
+	suite.Require().NoError(err)
+	defer dst.Close()
+	if !dst.Direct || dst.LogicalBlockSize <= minAlignSize {
+		suite.T().Skip("temp directory has no O_DIRECT alignment above the minimum")
+	}
+
+	data := make([]byte, 64*1024)
+	_, err = WriteContext(context.Background(), dst.File, data, uint64(len(data)), CopyOptions{ChunkSize: 32 * 1024, Alignment: minAlignSize})
+	assert.ErrorIs(suite.T(), err, ErrInvalidOptions)
+}
+
+func (suite *IOTestSuite) TestOpenDirectFallback() {
+	// procfs does not support O_DIRECT
+	f, err := OpenDirect("/proc/self/status", os.O_RDONLY)
+	suite.Require().NoError(err)
+	assert.False(suite.T(), f.Direct)
+	assert.Greater(suite.T(), f.LogicalBlockSize, 0)
+	assert.NoError(suite.T(), f.Close())
+}
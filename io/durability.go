@@ -0,0 +1,156 @@
+package io
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const defaultSyncBytes = 256 * 1024 * 1024
+
+// Durability selects how the written data is made durable before a run
+// returns.
+type Durability int
+
+const (
+	// DurabilityNone leaves the data in the page cache and the device
+	// write cache. A crash right after the run may lose it.
+	DurabilityNone Durability = iota
+	// DurabilitySync fdatasyncs the destination once everything is
+	// written.
+	DurabilitySync
+	// DurabilityPeriodic also fdatasyncs the destination every SyncBytes
+	// written, which bounds the dirty data at any time.
+	DurabilityPeriodic
+	// DurabilityFlush fdatasyncs the destination at the end and, when it
+	// is a block device, flushes and drops its buffer cache with
+	// BLKFLSBUF. BLKFLSBUF requires CAP_SYS_ADMIN.
+	DurabilityFlush
+)
+
+func (d Durability) String() string {
+	switch d {
+	case DurabilityNone:
+		return "none"
+	case DurabilitySync:
+		return "sync"
+	case DurabilityPeriodic:
+		return "periodic"
+	case DurabilityFlush:
+		return "flush"
+	default:
+		return fmt.Sprintf("Durability(%d)", int(d))
+	}
+}
+
+// Operations reported by DurabilityError.
+const (
+	OpFdatasync = "fdatasync"
+	OpFlushBuf  = "BLKFLSBUF"
+)
+
+// DurabilityError is returned when the data was written but could not be
+// made durable. Unlike an IOError it does not point at a chunk: any of the
+// data written since the previous sync may be lost.
+type DurabilityError struct {
+	Op   string
+	Path string
+	// Written is the number of bytes written to the destination when the
+	// sync failed.
+	Written uint64
+	Err     error
+}
+
+func (e *DurabilityError) Error() string {
+	return fmt.Sprintf("%s %s after %d bytes written: %v", e.Op, e.Path, e.Written, e.Err)
+}
+
+func (e *DurabilityError) Unwrap() error {
+	return e.Err
+}
+
+// syncer syncs the destination according to the Durability of a run.
+type syncer struct {
+	dst      *os.File
+	level    Durability
+	interval uint64
+
+	// mu serializes the syncs
+	mu sync.Mutex
+	// next is the number of written bytes due for the next periodic sync
+	next atomic.Uint64
+	// total is the time spent syncing, in nanoseconds
+	total atomic.Int64
+}
+
+func newSyncer(dst *os.File, opts CopyOptions) *syncer {
+	if opts.Durability == DurabilityNone {
+		return nil
+	}
+	s := &syncer{dst: dst, level: opts.Durability, interval: uint64(opts.SyncBytes)}
+	s.next.Store(s.interval)
+	return s
+}
+
+// periodic syncs the destination when another interval has been written.
+// It returns right away while another worker is syncing.
+func (s *syncer) periodic(written uint64) error {
+	if s == nil || s.level != DurabilityPeriodic || written < s.next.Load() {
+		return nil
+	}
+	if !s.mu.TryLock() {
+		return nil
+	}
+	defer s.mu.Unlock()
+	if written < s.next.Load() {
+		return nil
+	}
+	s.next.Store(written + s.interval)
+	return s.fdatasync(written)
+}
+
+// flush makes everything written so far durable.
+func (s *syncer) flush(written uint64) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fdatasync(written); err != nil {
+		return err
+	}
+	if s.level != DurabilityFlush {
+		return nil
+	}
+	info, err := s.dst.Stat()
+	if err != nil || !isBlockDevice(info) {
+		return err
+	}
+	t := startTimer()
+	defer t.stop(&s.total)
+	if err := unix.IoctlSetInt(int(s.dst.Fd()), unix.BLKFLSBUF, 0); err != nil {
+		return &DurabilityError{Op: OpFlushBuf, Path: s.dst.Name(), Written: written, Err: err}
+	}
+	return nil
+}
+
+func (s *syncer) fdatasync(written uint64) error {
+	t := startTimer()
+	defer t.stop(&s.total)
+	if err := unix.Fdatasync(int(s.dst.Fd())); err != nil {
+		return &DurabilityError{Op: OpFdatasync, Path: s.dst.Name(), Written: written, Err: err}
+	}
+	return nil
+}
+
+// elapsed returns the time spent syncing.
+func (s *syncer) elapsed() time.Duration {
+	if s == nil {
+		return 0
+	}
+	return time.Duration(s.total.Load())
+}
@@ -0,0 +1,71 @@
+package io
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"os"
+	"syscall"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *IOTestSuite) TestDurabilitySync() {
+	srcFile, data := suite.createRandomFile(1024 * 1024)
+	defer os.Remove(srcFile.Name())
+
+	for _, durability := range []Durability{DurabilitySync, DurabilityPeriodic, DurabilityFlush} {
+		dstFile, err := os.CreateTemp("", "dstfile")
+		suite.Require().NoError(err)
+		defer os.Remove(dstFile.Name())
+
+		opts := CopyOptions{ChunkSize: 64 * 1024, Durability: durability, SyncBytes: 128 * 1024}
+		res, err := CopyContext(context.Background(), srcFile, dstFile, opts)
+		suite.Require().NoError(err, durability.String())
+		assert.Positive(suite.T(), res.SyncTime, durability.String())
+
+		dstData, err := os.ReadFile(dstFile.Name())
+		suite.Require().NoError(err)
+		assert.Equal(suite.T(), data, dstData)
+	}
+}
+
+func (suite *IOTestSuite) TestDurabilityError() {
+	data := make([]byte, 64*1024)
+	_, err := rand.Read(data)
+	suite.Require().NoError(err)
+	// fdatasync fails with EINVAL on /dev/null
+	dst, err := os.OpenFile("/dev/null", os.O_WRONLY, 0)
+	suite.Require().NoError(err)
+	defer dst.Close()
+
+	opts := CopyOptions{ChunkSize: 4096, Durability: DurabilitySync}
+	res, err := WriteContext(context.Background(), dst, data, uint64(len(data)), opts)
+	var durabilityErr *DurabilityError
+	suite.Require().ErrorAs(err, &durabilityErr)
+	assert.ErrorIs(suite.T(), err, syscall.EINVAL)
+	assert.False(suite.T(), errors.As(err, new(*IOError)))
+	assert.Equal(suite.T(), OpFdatasync, durabilityErr.Op)
+	assert.Equal(suite.T(), uint64(len(data)), durabilityErr.Written)
+	assert.Empty(suite.T(), res.Failures)
+
+	// periodic syncs fail as soon as the first interval is written
+	opts = CopyOptions{ChunkSize: 4096, Readers: 1, Writers: 1, Durability: DurabilityPeriodic, SyncBytes: 8192}
+	_, err = WriteContext(context.Background(), dst, data, uint64(len(data)), opts)
+	suite.Require().ErrorAs(err, &durabilityErr)
+	assert.Equal(suite.T(), uint64(8192), durabilityErr.Written)
+}
+
+func (suite *IOTestSuite) TestDurabilityOptions() {
+	for _, opts := range []CopyOptions{
+		{Durability: DurabilityFlush + 1},
+		{Durability: DurabilityPeriodic, SyncBytes: -1},
+	} {
+		opts.setDefaults()
+		assert.ErrorIs(suite.T(), opts.validate(), ErrInvalidOptions)
+	}
+
+	opts := CopyOptions{Durability: DurabilityPeriodic}
+	opts.setDefaults()
+	assert.Equal(suite.T(), defaultSyncBytes, opts.SyncBytes)
+}
@@ -0,0 +1,109 @@
+package io
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+var (
+	ErrInvalidOptions     = errors.New("invalid copy options")
+	ErrVerifyMismatch     = errors.New("destination does not match the source")
+	ErrCheckpointMismatch = errors.New("checkpoint journal does not match the copy")
+	ErrInvalidImage       = errors.New("invalid image")
+	ErrUnsupportedImage   = errors.New("unsupported image")
+	ErrInvalidMapfile     = errors.New("invalid rescue map file")
+
+	// Preflight checks report one of these in a *PreflightError.
+	ErrNoSpace    = errors.New("not enough space on the destination")
+	ErrMounted    = errors.New("destination is mounted")
+	ErrSameFile   = errors.New("destination is the source")
+	ErrSectorSize = errors.New("incompatible sector size")
+)
+
+// CancelError is returned when the context of a copy is done before the copy
+// completes. Every byte of the destination below Offset has been committed,
+// so a caller can safely restart from there.
+type CancelError struct {
+	Offset uint64
+	Err    error
+}
+
+func (e *CancelError) Error() string {
+	return fmt.Sprintf("copy cancelled at offset %d: %v", e.Offset, e.Err)
+}
+
+func (e *CancelError) Unwrap() error {
+	return e.Err
+}
+
+// PreflightError is returned when the destination is found unfit for a copy
+// before anything is written to it. Err is one of ErrNoSpace, ErrMounted,
+// ErrSameFile and ErrSectorSize.
+type PreflightError struct {
+	Path   string
+	Err    error
+	Reason string
+}
+
+func (e *PreflightError) Error() string {
+	return fmt.Sprintf("%s: %v: %s", e.Path, e.Err, e.Reason)
+}
+
+func (e *PreflightError) Unwrap() error {
+	return e.Err
+}
+
+// Operations reported by IOError.
+const (
+	OpRead  = "read"
+	OpWrite = "write"
+	OpZero  = "zero"
+)
+
+// IOError is a read, write or zeroing of a range of a file or device that
+// failed. Err is the syscall.Errno when there is one, so that checks like
+// errors.Is(err, syscall.ENOSPC) see through it.
+type IOError struct {
+	Op string
+	// Path is the name of the file or device. It is empty for reads of
+	// sources that are not files, the buffer of WriteContext and the Source
+	// of CopyFrom.
+	Path string
+	// Offset is the absolute offset in the file or device, whatever offset
+	// the copy started at.
+	Offset uint64
+	Length uint64
+	Err    error
+}
+
+func (e *IOError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%s at offset %d, length %d: %v", e.Op, e.Offset, e.Length, e.Err)
+	}
+	return fmt.Sprintf("%s %s at offset %d, length %d: %v", e.Op, e.Path, e.Offset, e.Length, e.Err)
+}
+
+func (e *IOError) Unwrap() error {
+	return e.Err
+}
+
+// newIOError wraps err, which is returned as is when it already is an
+// *IOError. Path and syscall errors are reduced to their errno.
+func newIOError(op string, f *os.File, offset, length uint64, err error) *IOError {
+	var errno syscall.Errno
+	if !errors.As(err, new(*IOError)) && errors.As(err, &errno) {
+		err = errno
+	}
+	return asIOError(op, f.Name(), offset, length, err)
+}
+
+// asIOError returns the *IOError in err, or wraps err in a new one.
+func asIOError(op, path string, offset, length uint64, err error) *IOError {
+	var ioErr *IOError
+	if errors.As(err, &ioErr) {
+		return ioErr
+	}
+	return &IOError{Op: op, Path: path, Offset: offset, Length: length, Err: err}
+}
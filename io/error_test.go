@@ -0,0 +1,78 @@
+package io
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *IOTestSuite) TestIOErrorErrno() {
+	file, err := os.CreateTemp("", "readonly")
+	suite.Require().NoError(err)
+	defer os.Remove(file.Name())
+	readOnly, err := os.Open(file.Name())
+	suite.Require().NoError(err)
+	defer readOnly.Close()
+
+	_, err = PWrite(readOnly, make([]byte, 4096), 4096, 8192)
+	var ioErr *IOError
+	suite.Require().True(errors.As(err, &ioErr))
+	assert.Equal(suite.T(), OpWrite, ioErr.Op)
+	assert.Equal(suite.T(), file.Name(), ioErr.Path)
+	assert.Equal(suite.T(), uint64(8192), ioErr.Offset)
+	assert.Equal(suite.T(), uint64(4096), ioErr.Length)
+	assert.ErrorIs(suite.T(), err, syscall.EBADF)
+
+	writeOnly, err := os.OpenFile(file.Name(), os.O_WRONLY, 0)
+	suite.Require().NoError(err)
+	defer writeOnly.Close()
+	_, err = PReadExact(writeOnly, make([]byte, 4096), 4096, 0)
+	suite.Require().True(errors.As(err, &ioErr))
+	assert.Equal(suite.T(), OpRead, ioErr.Op)
+	assert.ErrorIs(suite.T(), err, syscall.EBADF)
+}
+
+func (suite *IOTestSuite) TestCopyFailures() {
+	srcFile, _ := suite.createRandomFile(1024 * 1024)
+	defer os.Remove(srcFile.Name())
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	// every write fails, all writers report before the run stops
+	plan := NewFaultPlan(Fault{Op: FaultWrite, Err: syscall.ENOSPC, Times: -1, Latency: 10 * time.Millisecond})
+	res, err := CopyContext(context.Background(), srcFile, dstFile, CopyOptions{ChunkSize: 64 * 1024, Writers: 4, Faults: plan})
+	assert.ErrorIs(suite.T(), err, syscall.ENOSPC)
+	suite.Require().NotNil(res)
+	suite.Require().NotEmpty(res.Failures)
+	assert.Same(suite.T(), res.Failures[0], err)
+	seen := map[uint64]bool{}
+	for _, f := range res.Failures {
+		assert.Equal(suite.T(), OpWrite, f.Op)
+		assert.Equal(suite.T(), dstFile.Name(), f.Path)
+		assert.Equal(suite.T(), uint64(64*1024), f.Length)
+		assert.False(suite.T(), seen[f.Offset], "offset %d reported twice", f.Offset)
+		seen[f.Offset] = true
+	}
+
+	// ranged copies report source offsets, not copy offsets
+	plan = NewFaultPlan(Fault{Op: FaultRead, Offset: 64 * 1024, AtOffset: true, Err: syscall.EIO})
+	_, err = CopyRange(context.Background(), srcFile, 256*1024, dstFile, 128*1024, 512*1024, CopyOptions{ChunkSize: 64 * 1024, Faults: plan})
+	var ioErr *IOError
+	suite.Require().ErrorAs(err, &ioErr)
+	assert.Equal(suite.T(), OpRead, ioErr.Op)
+	assert.Equal(suite.T(), srcFile.Name(), ioErr.Path)
+	assert.Equal(suite.T(), uint64(320*1024), ioErr.Offset)
+	assert.Equal(suite.T(), uint64(64*1024), ioErr.Length)
+
+	// buffers have no name
+	plan = NewFaultPlan(Fault{Op: FaultRead, Offset: 64 * 1024, AtOffset: true, Err: syscall.EIO})
+	_, err = WriteContext(context.Background(), dstFile, make([]byte, 256*1024), 256*1024, CopyOptions{ChunkSize: 64 * 1024, Faults: plan})
+	suite.Require().ErrorAs(err, &ioErr)
+	assert.Empty(suite.T(), ioErr.Path)
+	assert.Equal(suite.T(), "read at offset 65536, length 65536: input/output error", ioErr.Error())
+}
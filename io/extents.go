@@ -0,0 +1,218 @@
+package io
+
+import (
+	"cmp"
+	"errors"
+	"os"
+	"slices"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	FS_IOC_FIEMAP      = 0xc020660b
+	fiemapFlagSync     = 0x1
+	fiemapExtentLast   = 0x1
+	fiemapExtentsBatch = 64
+)
+
+// extent is the byte range [offset, offset+length). A hole extent is known
+// to read back as zeros without being read.
+type extent struct {
+	offset uint64
+	length uint64
+	hole   bool
+}
+
+func (e extent) end() uint64 {
+	return e.offset + e.length
+}
+
+// fiemapExtent mirrors struct fiemap_extent from linux/fiemap.h.
+type fiemapExtent struct {
+	logical  uint64
+	physical uint64
+	length   uint64
+	_        [2]uint64
+	flags    uint32
+	_        [3]uint32
+}
+
+// fiemap mirrors struct fiemap from linux/fiemap.h with room for a batch of
+// extents.
+type fiemap struct {
+	start         uint64
+	length        uint64
+	flags         uint32
+	mappedExtents uint32
+	extentCount   uint32
+	_             uint32
+	extents       [fiemapExtentsBatch]fiemapExtent
+}
+
+// sourceExtents returns the ranges of a regular file that hold data, rounded
+// out to chunkSize so that chunk boundaries are the same as for a full copy.
+// Knowing the layout is only an optimisation, so when neither SEEK_DATA nor
+// FIEMAP works the whole file is returned.
+func sourceExtents(f *os.File, size uint64, chunkSize uint64) []extent {
+	if size == 0 {
+		return nil
+	}
+	extents, err := seekDataExtents(f, size)
+	if err != nil {
+		extents, err = fiemapExtents(f, size)
+	}
+	if err != nil {
+		return []extent{{offset: 0, length: size}}
+	}
+	return alignExtents(extents, size, chunkSize)
+}
+
+// seekDataExtents walks the file with lseek(SEEK_DATA/SEEK_HOLE). The file
+// offset is restored afterwards.
+func seekDataExtents(f *os.File, size uint64) ([]extent, error) {
+	fd := int(f.Fd())
+	cur, err := unix.Seek(fd, 0, unix.SEEK_CUR)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Seek(fd, cur, unix.SEEK_SET) //nolint:errcheck
+
+	var extents []extent
+	var offset int64
+	for uint64(offset) < size {
+		data, err := unix.Seek(fd, offset, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			// no data past offset
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		hole, err := unix.Seek(fd, data, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, err
+		}
+		if uint64(hole) > size {
+			hole = int64(size)
+		}
+		extents = append(extents, extent{offset: uint64(data), length: uint64(hole - data)})
+		offset = hole
+	}
+	return extents, nil
+}
+
+// fiemapExtents asks the filesystem for the file's extent map through
+// FS_IOC_FIEMAP. Unwritten extents are reported too; they read back as zeros
+// and are dropped by the zero check.
+func fiemapExtents(f *os.File, size uint64) ([]extent, error) {
+	var extents []extent
+	var start uint64
+	for start < size {
+		fm := fiemap{
+			start:       start,
+			length:      size - start,
+			flags:       fiemapFlagSync,
+			extentCount: fiemapExtentsBatch,
+		}
+		if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), FS_IOC_FIEMAP, uintptr(unsafe.Pointer(&fm))); errno != 0 {
+			return nil, errno
+		}
+		if fm.mappedExtents == 0 {
+			break
+		}
+		for _, fe := range fm.extents[:fm.mappedExtents] {
+			extents = append(extents, extent{offset: fe.logical, length: fe.length})
+			start = fe.logical + fe.length
+			if fe.flags&fiemapExtentLast != 0 {
+				return extents, nil
+			}
+		}
+	}
+	return extents, nil
+}
+
+// alignExtents rounds every extent out to multiples of chunkSize, clamps it
+// to size and merges the ones that end up touching.
+func alignExtents(extents []extent, size uint64, chunkSize uint64) []extent {
+	var aligned []extent
+	for _, e := range extents {
+		start := e.offset / chunkSize * chunkSize
+		end := (e.end() + chunkSize - 1) / chunkSize * chunkSize
+		if end > size {
+			end = size
+		}
+		if start >= end {
+			continue
+		}
+		if n := len(aligned); n > 0 && aligned[n-1].end() >= start {
+			if end > aligned[n-1].end() {
+				aligned[n-1].length = end - aligned[n-1].offset
+			}
+			continue
+		}
+		aligned = append(aligned, extent{offset: start, length: end - start})
+	}
+	return aligned
+}
+
+// subtractExtents removes the sorted ranges in done from the sorted extents.
+// It also returns how many data bytes, holes excluded, were removed.
+func subtractExtents(extents, done []extent) ([]extent, uint64) {
+	var rest []extent
+	var removed uint64
+	for _, e := range extents {
+		cursor := e.offset
+		for _, d := range done {
+			if d.end() <= cursor || d.offset >= e.end() {
+				continue
+			}
+			if d.offset > cursor {
+				rest = append(rest, extent{offset: cursor, length: d.offset - cursor, hole: e.hole})
+			}
+			end := min(d.end(), e.end())
+			if !e.hole {
+				removed += end - max(d.offset, cursor)
+			}
+			cursor = end
+		}
+		if cursor < e.end() {
+			rest = append(rest, extent{offset: cursor, length: e.end() - cursor, hole: e.hole})
+		}
+	}
+	return rest, removed
+}
+
+// mergeExtents sorts extents and merges the overlapping or touching ones.
+func mergeExtents(extents []extent) []extent {
+	sorted := slices.Clone(extents)
+	slices.SortFunc(sorted, func(a, b extent) int {
+		return cmp.Compare(a.offset, b.offset)
+	})
+	var merged []extent
+	for _, e := range sorted {
+		if n := len(merged); n > 0 && merged[n-1].end() >= e.offset {
+			if e.end() > merged[n-1].end() {
+				merged[n-1].length = e.end() - merged[n-1].offset
+			}
+			continue
+		}
+		merged = append(merged, e)
+	}
+	return merged
+}
+
+// clipExtents returns the parts of the sorted extents within [start, end),
+// shifted so that start becomes offset 0.
+func clipExtents(extents []extent, start, end uint64) []extent {
+	var clipped []extent
+	for _, e := range extents {
+		from, to := max(e.offset, start), min(e.end(), end)
+		if from >= to {
+			continue
+		}
+		clipped = append(clipped, extent{offset: from - start, length: to - from, hole: e.hole})
+	}
+	return clipped
+}
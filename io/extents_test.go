@@ -0,0 +1,110 @@
+package io
+
+import (
+	"context"
+	"crypto/rand"
+	"os"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// createSparseFile returns a 64M file holding random data only at 8M (1M
+// long) and at 40M+1000 (777 bytes long); everything else is a hole.
+func (suite *IOTestSuite) createSparseFile() (*os.File, []byte) {
+	srcFile, err := os.CreateTemp("", "sparse_file")
+	suite.Require().NoError(err)
+
+	data := make([]byte, 64*1024*1024) // 64M
+	_, err = rand.Read(data[8*1024*1024 : 9*1024*1024])
+	suite.Require().NoError(err)
+	_, err = rand.Read(data[40*1024*1024+1000 : 40*1024*1024+1777])
+	suite.Require().NoError(err)
+
+	suite.Require().NoError(srcFile.Truncate(int64(len(data))))
+	_, err = srcFile.WriteAt(data[8*1024*1024:9*1024*1024], 8*1024*1024)
+	suite.Require().NoError(err)
+	_, err = srcFile.WriteAt(data[40*1024*1024+1000:40*1024*1024+1777], 40*1024*1024+1000)
+	suite.Require().NoError(err)
+	return srcFile, data
+}
+
+func (suite *IOTestSuite) TestSourceExtents() {
+	srcFile, data := suite.createSparseFile()
+	defer os.Remove(srcFile.Name())
+	_, err := srcFile.Seek(123, 0)
+	suite.Require().NoError(err)
+
+	// Filesystems allocate in blocks, so only check that both data ranges
+	// are covered and that most of the file is left out
+	for name, fn := range map[string]func(*os.File, uint64) ([]extent, error){
+		"SEEK_DATA": seekDataExtents,
+		"FIEMAP":    fiemapExtents,
+	} {
+		extents, err := fn(srcFile, uint64(len(data)))
+		if err != nil {
+			suite.T().Logf("%s is not supported here: %v", name, err)
+			continue
+		}
+		aligned := alignExtents(extents, uint64(len(data)), 4096)
+		var covered uint64
+		for _, e := range aligned {
+			covered += e.length
+		}
+		assert.Less(suite.T(), covered, uint64(4*1024*1024), name)
+		assert.True(suite.T(), extentsCover(aligned, 8*1024*1024, 9*1024*1024), name)
+		assert.True(suite.T(), extentsCover(aligned, 40*1024*1024+1000, 40*1024*1024+1777), name)
+	}
+
+	// The file offset is left untouched
+	offset, err := srcFile.Seek(0, 1)
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), int64(123), offset)
+}
+
+func (suite *IOTestSuite) TestAlignExtents() {
+	extents := []extent{
+		{offset: 100, length: 100},
+		{offset: 4000, length: 200},
+		{offset: 16384, length: 1},
+		{offset: 20000, length: 5000},
+	}
+	assert.Equal(suite.T(), []extent{
+		{offset: 0, length: 8192},
+		{offset: 16384, length: 24000 - 16384},
+	}, alignExtents(extents, 24000, 4096))
+}
+
+func (suite *IOTestSuite) TestCopySparseFile() {
+	srcFile, data := suite.createSparseFile()
+	defer os.Remove(srcFile.Name())
+
+	// Create a temporary dstFile for testing
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+	suite.Require().NoError(dstFile.Truncate(int64(len(data))))
+
+	var last Progress
+	opts := CopyOptions{ChunkSize: 1024 * 1024, Progress: func(p Progress) { last = p }}
+	_, err = CopyContext(context.Background(), srcFile, dstFile, opts)
+	suite.Require().NoError(err)
+
+	// Holes are never read
+	assert.Less(suite.T(), last.BytesRead, uint64(len(data)))
+	assert.Equal(suite.T(), uint64(len(data)), last.BytesRead+last.HoleBytes)
+	assert.Equal(suite.T(), float64(100), last.Percent())
+
+	dstData := make([]byte, len(data))
+	_, err = dstFile.ReadAt(dstData, 0)
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), data, dstData)
+}
+
+func extentsCover(extents []extent, start, end uint64) bool {
+	for _, e := range extents {
+		if e.offset <= start && end <= e.end() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package io
+
+import (
+	"context"
+	stdio "io"
+	"sync"
+	"time"
+)
+
+// FaultOp selects the I/O path a Fault applies to.
+type FaultOp int
+
+const (
+	// FaultRead injects into the reads of source chunks.
+	FaultRead FaultOp = 1 << iota
+	// FaultWrite injects into the writes of destination chunks, zero
+	// ranges included.
+	FaultWrite
+)
+
+// Fault is a failure injected into a chunk. Which chunks it hits is chosen
+// by Op, Chunk and Offset; what happens to them by Err, Short and Latency.
+type Fault struct {
+	// Op is the path the fault applies to, both when zero.
+	Op FaultOp
+	// Chunk hits only the Nth chunk on the path, counted from 1 in the
+	// order chunks are processed, retries included. With several readers
+	// or writers that order varies, use a single one or Offset for
+	// deterministic runs.
+	Chunk int
+	// Offset hits only the chunk covering it when AtOffset is set. Offsets
+	// are counted from the start of the copy.
+	Offset   uint64
+	AtOffset bool
+	// Times is how many chunks the fault hits, once when zero and without
+	// limit when negative.
+	Times int
+
+	// Err is returned by the chunk, for instance syscall.EIO. It defaults
+	// to io.ErrUnexpectedEOF for short reads, io.ErrShortWrite for short
+	// writes and ErrFaultInject otherwise. A fault with only Latency set
+	// does not fail.
+	Err error
+	// Short cuts the chunk to its first Short bytes. The rest is neither
+	// read nor written.
+	Short int
+	// Latency delays the chunk before its I/O is issued.
+	Latency time.Duration
+}
+
+// FaultPlan injects faults into a run through CopyOptions.Faults, so that
+// partial failures, cancellation, retries and resumes can be tested
+// deterministically. A chunk is hit by the first matching fault only. A plan
+// keeps its counters across runs; use a new one per run.
+type FaultPlan struct {
+	faults []Fault
+
+	mu    sync.Mutex
+	seen  map[FaultOp]int
+	fired []int
+}
+
+func NewFaultPlan(faults ...Fault) *FaultPlan {
+	return &FaultPlan{faults: faults, seen: map[FaultOp]int{}, fired: make([]int, len(faults))}
+}
+
+// Hits returns the number of chunks hit by a fault so far.
+func (p *FaultPlan) Hits() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var hits int
+	for _, n := range p.fired {
+		hits += n
+	}
+	return hits
+}
+
+// hit counts a chunk of length bytes at offset on op and returns the fault
+// it triggers, if any.
+func (p *FaultPlan) hit(op FaultOp, offset, length uint64) *Fault {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.seen[op]++
+	for i := range p.faults {
+		f := &p.faults[i]
+		if f.Op != 0 && f.Op&op == 0 {
+			continue
+		}
+		if f.Chunk != 0 && f.Chunk != p.seen[op] {
+			continue
+		}
+		if f.AtOffset && (f.Offset < offset || f.Offset >= offset+length) {
+			continue
+		}
+		times := f.Times
+		if times == 0 {
+			times = 1
+		}
+		if times > 0 && p.fired[i] >= times {
+			continue
+		}
+		p.fired[i]++
+		return f
+	}
+	return nil
+}
+
+// wait sleeps for the latency of f unless ctx is done first.
+func (f *Fault) wait(ctx context.Context) error {
+	if f.Latency <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(f.Latency)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// short returns how many of length bytes go through, and whether the
+// chunk is cut at all.
+func (f *Fault) short(length int) (int, bool) {
+	if f.Short <= 0 || f.Short >= length {
+		return length, false
+	}
+	return f.Short, true
+}
+
+// err returns the error the chunk fails with, nil for latency only faults.
+func (f *Fault) err(op FaultOp, cut bool) error {
+	switch {
+	case f.Err != nil:
+		return f.Err
+	case cut && op == FaultRead:
+		return stdio.ErrUnexpectedEOF
+	case cut:
+		return stdio.ErrShortWrite
+	case f.Short > 0 || f.Latency > 0:
+		return nil
+	}
+	return ErrFaultInject
+}
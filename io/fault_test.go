@@ -0,0 +1,76 @@
+package io
+
+import (
+	"context"
+	"errors"
+	stdio "io"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *IOTestSuite) TestFaultPlanRead() {
+	srcFile, _ := suite.createRandomFile(1024 * 1024)
+	defer os.Remove(srcFile.Name())
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	plan := NewFaultPlan(Fault{Op: FaultRead, Chunk: 3, Err: syscall.EIO})
+	_, err = CopyContext(context.Background(), srcFile, dstFile, CopyOptions{ChunkSize: 64 * 1024, Readers: 1, Faults: plan})
+	assert.ErrorIs(suite.T(), err, syscall.EIO)
+	assert.Equal(suite.T(), 1, plan.Hits())
+
+	plan = NewFaultPlan(Fault{Op: FaultRead, Offset: 500 * 1024, AtOffset: true, Short: 100})
+	_, err = CopyContext(context.Background(), srcFile, dstFile, CopyOptions{ChunkSize: 64 * 1024, Faults: plan})
+	assert.ErrorIs(suite.T(), err, stdio.ErrUnexpectedEOF)
+	assert.Equal(suite.T(), 1, plan.Hits())
+}
+
+func (suite *IOTestSuite) TestFaultPlanShortWrite() {
+	srcFile, data := suite.createRandomFile(1024 * 1024)
+	defer os.Remove(srcFile.Name())
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	// the last chunk is cut short, everything before it lands
+	const offset = 1024*1024 - 64*1024
+	plan := NewFaultPlan(Fault{Op: FaultWrite, Offset: offset, AtOffset: true, Short: 4096})
+	_, err = CopyContext(context.Background(), srcFile, dstFile, CopyOptions{ChunkSize: 64 * 1024, Readers: 1, Writers: 1, Faults: plan})
+	assert.ErrorIs(suite.T(), err, stdio.ErrShortWrite)
+
+	dstData, err := os.ReadFile(dstFile.Name())
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), data[:offset+4096], dstData)
+}
+
+func (suite *IOTestSuite) TestFaultPlanLatency() {
+	srcFile, data := suite.createRandomFile(1024 * 1024)
+	defer os.Remove(srcFile.Name())
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	// latency alone slows chunks down without failing them
+	plan := NewFaultPlan(Fault{Op: FaultWrite, Latency: 20 * time.Millisecond, Times: 2})
+	start := time.Now()
+	_, err = CopyContext(context.Background(), srcFile, dstFile, CopyOptions{ChunkSize: 64 * 1024, Writers: 1, Faults: plan})
+	suite.Require().NoError(err)
+	assert.GreaterOrEqual(suite.T(), time.Since(start), 40*time.Millisecond)
+	assert.Equal(suite.T(), 2, plan.Hits())
+	dstData, err := os.ReadFile(dstFile.Name())
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), data, dstData)
+
+	// a stuck device does not hold up cancellation
+	plan = NewFaultPlan(Fault{Latency: time.Hour, Times: -1})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = CopyContext(ctx, srcFile, dstFile, CopyOptions{ChunkSize: 64 * 1024, Faults: plan})
+	var cancelErr *CancelError
+	assert.True(suite.T(), errors.As(err, &cancelErr))
+	assert.ErrorIs(suite.T(), err, context.DeadlineExceeded)
+}
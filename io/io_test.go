@@ -1,9 +1,11 @@
 package io
 
 import (
+	"context"
 	"crypto/rand"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
@@ -17,6 +19,20 @@ func TestIOTestSuite(t *testing.T) {
 	suite.Run(t, new(IOTestSuite))
 }
 
+// createRandomFile returns a temporary file holding size random bytes, and
+// the bytes.
+func (suite *IOTestSuite) createRandomFile(size int) (*os.File, []byte) {
+	srcFile, err := os.CreateTemp("", "random_file")
+	suite.Require().NoError(err)
+
+	data := make([]byte, size)
+	_, err = rand.Read(data)
+	suite.Require().NoError(err)
+	_, err = srcFile.WriteAt(data, 0)
+	suite.Require().NoError(err)
+	return srcFile, data
+}
+
 func (suite *IOTestSuite) TestWriteAlignSmallFile() {
 	// Create a temporary srcFile for testing
 	srcFile, err := os.CreateTemp("", "512B_file")
@@ -124,11 +140,10 @@ func (suite *IOTestSuite) TestWriteUnalignError() {
 	_, err = rand.Read(data)
 	suite.Require().NoError(err)
 
-	os.Setenv("HARV_FAULT", "1")
 	// Write the data to the file using IOWrite
-	err = Write(srcFile, data, uint64(len(data)), 4096)
-	os.Setenv("HARV_FAULT", "")
-	assert.Equal(suite.T(), err, ErrFaultInject)
+	_, err = WriteContext(context.Background(), srcFile, data, uint64(len(data)),
+		CopyOptions{ChunkSize: 4096, Faults: NewFaultPlan(Fault{Op: FaultWrite})})
+	assert.ErrorIs(suite.T(), err, ErrFaultInject)
 }
 
 func (suite *IOTestSuite) TestWriteAlignBigChunk() {
@@ -368,10 +383,9 @@ func (suite *IOTestSuite) TestCopyUnalignError() {
 	defer os.Remove(dstFile.Name())
 
 	// Copy the data from srcFile to dstFile using Copy
-	os.Setenv("HARV_FAULT", "1")
-	err = Copy(srcFile, dstFile, 4096)
-	os.Setenv("HARV_FAULT", "")
-	assert.Equal(suite.T(), err, ErrFaultInject)
+	_, err = CopyContext(context.Background(), srcFile, dstFile,
+		CopyOptions{ChunkSize: 4096, Faults: NewFaultPlan(Fault{Op: FaultWrite})})
+	assert.ErrorIs(suite.T(), err, ErrFaultInject)
 }
 
 func (suite *IOTestSuite) TestCopyAlignBigChunk() {
@@ -454,6 +468,71 @@ func (suite *IOTestSuite) TestCopyUnalignBigChunk() {
 	assert.Equal(suite.T(), data, dstData)
 }
 
+func (suite *IOTestSuite) TestCopyContextCancelled() {
+	srcFile, _ := suite.createRandomFile(4 * 1024 * 1024)
+	defer os.Remove(srcFile.Name())
+
+	// Create a temporary dstFile for testing
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	// A context cancelled up front must not copy anything
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = CopyContext(ctx, srcFile, dstFile, CopyOptions{ChunkSize: 4096})
+	var cancelErr *CancelError
+	suite.Require().ErrorAs(err, &cancelErr)
+	assert.ErrorIs(suite.T(), err, context.Canceled)
+	assert.Equal(suite.T(), uint64(0), cancelErr.Offset)
+}
+
+func (suite *IOTestSuite) TestCopyContextCancelMidway() {
+	srcFile, data := suite.createRandomFile(135 * 1024 * 1024)
+	defer os.Remove(srcFile.Name())
+
+	// Create a temporary dstFile for testing
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = CopyContext(ctx, srcFile, dstFile, CopyOptions{ChunkSize: 4096})
+	if err == nil {
+		suite.T().Skip("copy finished before the context expired")
+	}
+
+	// Everything below the committed offset must already be on the destination
+	var cancelErr *CancelError
+	suite.Require().ErrorAs(err, &cancelErr)
+	assert.ErrorIs(suite.T(), err, context.DeadlineExceeded)
+	suite.Require().Less(cancelErr.Offset, uint64(len(data)))
+	dstData := make([]byte, cancelErr.Offset)
+	_, err = dstFile.ReadAt(dstData, 0)
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), data[:cancelErr.Offset], dstData)
+}
+
+func (suite *IOTestSuite) TestWriteContextCancelled() {
+	// Create a temporary dstFile for testing
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	// Generate random data
+	data := make([]byte, 4*1024*1024) // 4M
+	_, err = rand.Read(data)
+	suite.Require().NoError(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = WriteContext(ctx, dstFile, data, uint64(len(data)), CopyOptions{ChunkSize: 4096})
+	var cancelErr *CancelError
+	suite.Require().ErrorAs(err, &cancelErr)
+	assert.ErrorIs(suite.T(), err, context.Canceled)
+}
+
 func BenchmarkPWriteBigChunkZero(b *testing.B) {
 	srcFile, _ := os.CreateTemp("", "135M_file")
 
@@ -482,3 +561,50 @@ func BenchmarkPWriteSmallChunk(b *testing.B) {
 	b.ResetTimer()
 	_ = Write(srcFile, data, uint64(len(data)), 4096)
 }
+
+func benchmarkCopy(b *testing.B, size int, chunkSize int, random bool) {
+	srcFile, _ := os.CreateTemp("", "bench_src")
+	defer os.Remove(srcFile.Name())
+	dstFile, _ := os.CreateTemp("", "bench_dst")
+	defer os.Remove(dstFile.Name())
+
+	data := make([]byte, size)
+	if random {
+		_, _ = rand.Read(data)
+	} else {
+		// fully allocated zeros, so that the zero check is measured
+		data[len(data)-1] = 1
+	}
+	_, _ = srcFile.WriteAt(data, 0)
+
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := Copy(srcFile, dstFile, chunkSize); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCopyBigChunk(b *testing.B) {
+	benchmarkCopy(b, 128*1024*1024, 4194304, true)
+}
+
+func BenchmarkCopySmallChunk(b *testing.B) {
+	benchmarkCopy(b, 32*1024*1024, 4096, true)
+}
+
+func BenchmarkCopyZero(b *testing.B) {
+	benchmarkCopy(b, 128*1024*1024, 4194304, false)
+}
+
+func BenchmarkIsZeroChunk(b *testing.B) {
+	buf := make([]byte, 4194304)
+	b.SetBytes(int64(len(buf)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !isZeroChunk(buf) {
+			b.Fatal("not zero")
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package io
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	stdio "io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+const defaultMetricsNamespace = "harvester_io"
+
+var (
+	// durationBuckets are the upper bounds, in seconds, of the phase
+	// duration histogram
+	durationBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 3600}
+	// throughputBuckets are the upper bounds, in bytes per second, of the
+	// throughput histogram
+	throughputBuckets = []float64{1 << 20, 4 << 20, 16 << 20, 64 << 20, 256 << 20, 1 << 30, 4 << 30}
+)
+
+// Phases reported by Metrics.
+var metricsPhases = []string{"transfer", "finish", "sync", "verify", "total"}
+
+// Metrics accumulates the Stats of the runs it is set on, through
+// CopyOptions.Metrics, as Prometheus counters and histograms. It is safe for
+// concurrent runs. WriteTo and ServeHTTP expose the metrics in the Prometheus
+// text format, so that node agents can export them without a client library.
+type Metrics struct {
+	namespace string
+
+	mu           sync.Mutex
+	runs         map[string]uint64
+	bytesRead    uint64
+	bytesWritten uint64
+	chunks       uint64
+	zeroChunks   uint64
+	retries      uint64
+	durations    map[string]*histogram
+	throughput   *histogram
+}
+
+// NewMetrics returns metrics named with the given namespace prefix, which
+// defaults to harvester_io.
+func NewMetrics(namespace string) *Metrics {
+	if namespace == "" {
+		namespace = defaultMetricsNamespace
+	}
+	m := &Metrics{
+		namespace:  namespace,
+		runs:       map[string]uint64{},
+		durations:  map[string]*histogram{},
+		throughput: newHistogram(throughputBuckets),
+	}
+	for _, phase := range metricsPhases {
+		m.durations[phase] = newHistogram(durationBuckets)
+	}
+	return m
+}
+
+// observe accounts a finished run.
+func (m *Metrics) observe(s Stats, err error) {
+	if m == nil {
+		return
+	}
+	outcome := "success"
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		outcome = "cancelled"
+	case err != nil:
+		outcome = "error"
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.runs[outcome]++
+	m.bytesRead += s.BytesRead
+	m.bytesWritten += s.BytesWritten
+	m.chunks += s.Chunks
+	m.zeroChunks += s.ZeroChunks
+	m.retries += s.Retries
+	m.durations["transfer"].observe(s.TransferTime.Seconds())
+	m.durations["finish"].observe(s.FinishTime.Seconds())
+	if s.SyncTime > 0 {
+		m.durations["sync"].observe(s.SyncTime.Seconds())
+	}
+	if s.VerifyTime > 0 {
+		m.durations["verify"].observe(s.VerifyTime.Seconds())
+	}
+	m.durations["total"].observe(s.Duration.Seconds())
+	if err == nil && s.BytesRead > 0 {
+		m.throughput.observe(s.Throughput())
+	}
+}
+
+// WriteTo writes the metrics to w in the Prometheus text format.
+func (m *Metrics) WriteTo(w stdio.Writer) (int64, error) {
+	var b bytes.Buffer
+	m.mu.Lock()
+	name := m.namespace + "_copy_runs_total"
+	writeHeader(&b, name, "counter", "Copy and write runs by outcome.")
+	outcomes := make([]string, 0, len(m.runs))
+	for outcome := range m.runs {
+		outcomes = append(outcomes, outcome)
+	}
+	slices.Sort(outcomes)
+	for _, outcome := range outcomes {
+		fmt.Fprintf(&b, "%s{outcome=%q} %d\n", name, outcome, m.runs[outcome])
+	}
+	for _, counter := range []struct {
+		name, help string
+		value      uint64
+	}{
+		{"copy_read_bytes_total", "Source bytes read.", m.bytesRead},
+		{"copy_written_bytes_total", "Bytes written to destinations.", m.bytesWritten},
+		{"copy_chunks_total", "Chunks copied.", m.chunks},
+		{"copy_zero_chunks_total", "All-zero chunks skipped or zeroed instead of written.", m.zeroChunks},
+		{"copy_retries_total", "Chunk reads and writes tried again.", m.retries},
+	} {
+		name := m.namespace + "_" + counter.name
+		writeHeader(&b, name, "counter", counter.help)
+		fmt.Fprintf(&b, "%s %d\n", name, counter.value)
+	}
+	name = m.namespace + "_copy_duration_seconds"
+	writeHeader(&b, name, "histogram", "Duration of the copy phases.")
+	for _, phase := range metricsPhases {
+		m.durations[phase].write(&b, name, fmt.Sprintf("phase=%q,", phase))
+	}
+	name = m.namespace + "_copy_throughput_bytes_per_second"
+	writeHeader(&b, name, "histogram", "Average read throughput of successful copies.")
+	m.throughput.write(&b, name, "")
+	m.mu.Unlock()
+	return b.WriteTo(w)
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = m.WriteTo(w)
+}
+
+func writeHeader(b *bytes.Buffer, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// histogram counts observations into cumulative buckets.
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// write writes the series of h, labels being a label list prefix ending
+// with a comma, or empty.
+func (h *histogram) write(b *bytes.Buffer, name, labels string) {
+	for i, bound := range h.bounds {
+		fmt.Fprintf(b, "%s_bucket{%sle=%q} %d\n", name, labels, formatFloat(bound), h.counts[i])
+	}
+	fmt.Fprintf(b, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.count)
+	if labels != "" {
+		labels = "{" + labels[:len(labels)-1] + "}"
+	}
+	fmt.Fprintf(b, "%s_sum%s %s\n", name, labels, formatFloat(h.sum))
+	fmt.Fprintf(b, "%s_count%s %d\n", name, labels, h.count)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
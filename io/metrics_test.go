@@ -0,0 +1,69 @@
+package io
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *IOTestSuite) TestMetrics() {
+	srcFile, _ := suite.createRandomFile(256 * 1024)
+	defer os.Remove(srcFile.Name())
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	metrics := NewMetrics("")
+	opts := CopyOptions{ChunkSize: 64 * 1024, Metrics: metrics}
+	_, err = CopyContext(context.Background(), srcFile, dstFile, opts)
+	suite.Require().NoError(err)
+	opts.Faults = NewFaultPlan(Fault{Op: FaultWrite, Err: syscall.EIO})
+	_, err = CopyContext(context.Background(), srcFile, dstFile, opts)
+	suite.Require().Error(err)
+
+	var b strings.Builder
+	_, err = metrics.WriteTo(&b)
+	suite.Require().NoError(err)
+	out := b.String()
+	for _, line := range []string{
+		"# TYPE harvester_io_copy_runs_total counter",
+		`harvester_io_copy_runs_total{outcome="error"} 1`,
+		`harvester_io_copy_runs_total{outcome="success"} 1`,
+		"harvester_io_copy_read_bytes_total ",
+		"harvester_io_copy_zero_chunks_total 0",
+		"# TYPE harvester_io_copy_duration_seconds histogram",
+		`harvester_io_copy_duration_seconds_bucket{phase="transfer",le="+Inf"} 2`,
+		`harvester_io_copy_duration_seconds_count{phase="total"} 2`,
+		`harvester_io_copy_duration_seconds_count{phase="verify"} 0`,
+		`harvester_io_copy_throughput_bytes_per_second_bucket{le="+Inf"} 1`,
+		"harvester_io_copy_throughput_bytes_per_second_count 1",
+	} {
+		assert.Contains(suite.T(), out, line)
+	}
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(suite.T(), out, rec.Body.String())
+	assert.Contains(suite.T(), rec.Header().Get("Content-Type"), "version=0.0.4")
+}
+
+func (suite *IOTestSuite) TestHistogram() {
+	h := newHistogram([]float64{1, 10})
+	for _, v := range []float64{0.5, 1, 5, 50} {
+		h.observe(v)
+	}
+	var b bytes.Buffer
+	h.write(&b, "h", `phase="x",`)
+	assert.Equal(suite.T(), strings.Join([]string{
+		`h_bucket{phase="x",le="1"} 2`,
+		`h_bucket{phase="x",le="10"} 3`,
+		`h_bucket{phase="x",le="+Inf"} 4`,
+		`h_sum{phase="x"} 56.5`,
+		`h_count{phase="x"} 4`,
+	}, "\n")+"\n", b.String())
+}
@@ -0,0 +1,149 @@
+package io
+
+import (
+	"crypto"
+	"fmt"
+	"time"
+)
+
+const (
+	minAlignSize       = 512
+	defaultChunkSize   = 4194304
+	defaultConcurrency = 8
+	maxConcurrency     = 256
+	// maxQueueDepth bounds the chunk buffers allocated up front
+	maxQueueDepth = 2 * maxConcurrency
+)
+
+// CopyOptions tunes a single Copy or Write run. The zero value of every field
+// selects its default.
+type CopyOptions struct {
+	// Readers is the number of goroutines reading the source. It defaults
+	// to 8.
+	Readers int
+	// Writers is the number of goroutines writing the destination. It
+	// defaults to 8. A single reader and writer copy sequentially, which
+	// suits spinning disks.
+	Writers int
+	// QueueDepth is the number of chunks that may wait between the readers
+	// and the writers. It defaults to twice the number of readers and may
+	// be at most 512.
+	QueueDepth int
+	// ChunkSize is the size of a single read/write unit. It must be a
+	// multiple of Alignment and no larger than 4MiB, which is the default.
+	ChunkSize int
+	// Alignment is the granularity chunks and offsets are aligned to. It
+	// must be a power of two of at least 512 and defaults to 4096.
+	Alignment int
+
+	// ZeroPolicy selects what happens to the destination where the source
+	// is all zeros. It defaults to ZeroSkip.
+	ZeroPolicy ZeroPolicy
+
+	// Verify reads the destination back once all chunks are written and
+	// compares it with the source, chunk by chunk.
+	Verify bool
+	// VerifyHash is the hash used for verification and for the image
+	// digest. It defaults to SHA-256.
+	VerifyHash crypto.Hash
+
+	// Checkpoint is the path of a journal recording the finished chunk
+	// ranges of a Copy, so that ResumeCopy can pick up an interrupted copy.
+	// The journal is removed once the copy completes.
+	Checkpoint string
+	// CheckpointSyncInterval is how often the journal is fsynced. It
+	// defaults to five seconds.
+	CheckpointSyncInterval time.Duration
+
+	// RateLimiter, when set, throttles the bytes written by all workers.
+	RateLimiter *RateLimiter
+
+	// Progress, when set, receives a report every ProgressInterval and a
+	// final one once the run is over.
+	Progress ProgressFunc
+	// ProgressInterval defaults to one second.
+	ProgressInterval time.Duration
+
+	// Durability selects how the written data is made durable before the
+	// run returns. It defaults to DurabilityNone.
+	Durability Durability
+	// SyncBytes is how many bytes DurabilityPeriodic writes between two
+	// syncs. It defaults to 256MiB.
+	SyncBytes int
+
+	// Retry retries chunks that fail with transient errors. By default
+	// the first failure stops the run.
+	Retry RetryPolicy
+
+	// Metrics, when set, accounts the Stats of the run once it is over.
+	Metrics *Metrics
+
+	// Faults, when set, injects failures into the reads and writes of the
+	// run. It is meant for tests.
+	Faults *FaultPlan
+}
+
+func (o *CopyOptions) setDefaults() {
+	if o.Readers == 0 {
+		o.Readers = defaultConcurrency
+	}
+	if o.Writers == 0 {
+		o.Writers = defaultConcurrency
+	}
+	if o.QueueDepth == 0 {
+		o.QueueDepth = o.Readers * 2
+	}
+	if o.ChunkSize == 0 {
+		o.ChunkSize = defaultChunkSize
+	}
+	if o.Alignment == 0 {
+		o.Alignment = baseAlignSize
+	}
+	if o.Durability == DurabilityPeriodic && o.SyncBytes == 0 {
+		o.SyncBytes = defaultSyncBytes
+	}
+	o.Retry.setDefaults()
+}
+
+func (o *CopyOptions) validate() error {
+	if o.Readers < 1 || o.Readers > maxConcurrency {
+		return fmt.Errorf("%w: readers must be between 1 and %d, got %d", ErrInvalidOptions, maxConcurrency, o.Readers)
+	}
+	if o.Writers < 1 || o.Writers > maxConcurrency {
+		return fmt.Errorf("%w: writers must be between 1 and %d, got %d", ErrInvalidOptions, maxConcurrency, o.Writers)
+	}
+	if o.QueueDepth < 1 || o.QueueDepth > maxQueueDepth {
+		return fmt.Errorf("%w: queue depth must be between 1 and %d, got %d", ErrInvalidOptions, maxQueueDepth, o.QueueDepth)
+	}
+	if o.Alignment < minAlignSize || o.Alignment&(o.Alignment-1) != 0 {
+		return fmt.Errorf("%w: alignment must be a power of two of at least %d, got %d", ErrInvalidOptions, minAlignSize, o.Alignment)
+	}
+	if o.ChunkSize < 0 {
+		return fmt.Errorf("%w: chunk size must be positive, got %d", ErrInvalidOptions, o.ChunkSize)
+	}
+	if o.ChunkSize > maxChunkSize {
+		return fmt.Errorf("%w: chunk size is too large, max chunk size is %d", ErrInvalidOptions, maxChunkSize)
+	}
+	if o.ChunkSize%o.Alignment != 0 {
+		return fmt.Errorf("%w: chunk size must be a multiple of %d", ErrInvalidOptions, o.Alignment)
+	}
+	if o.ZeroPolicy < ZeroSkip || o.ZeroPolicy > ZeroDiscard {
+		return fmt.Errorf("%w: unknown zero policy %d", ErrInvalidOptions, o.ZeroPolicy)
+	}
+	if o.VerifyHash != 0 && !o.VerifyHash.Available() {
+		return fmt.Errorf("%w: verify hash %v is not available", ErrInvalidOptions, o.VerifyHash)
+	}
+	if o.CheckpointSyncInterval < 0 {
+		return fmt.Errorf("%w: checkpoint sync interval must not be negative", ErrInvalidOptions)
+	}
+	if o.ProgressInterval < 0 {
+		return fmt.Errorf("%w: progress interval must not be negative", ErrInvalidOptions)
+	}
+	if o.Durability < DurabilityNone || o.Durability > DurabilityFlush {
+		return fmt.Errorf("%w: unknown durability %d", ErrInvalidOptions, o.Durability)
+	}
+	if o.SyncBytes < 0 {
+		return fmt.Errorf("%w: sync bytes must not be negative", ErrInvalidOptions)
+	}
+	return o.Retry.validate()
+}
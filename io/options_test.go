@@ -0,0 +1,66 @@
+package io
+
+import (
+	"context"
+	"os"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *IOTestSuite) TestCopyOptionsDefaults() {
+	opts := CopyOptions{Readers: 3}
+	opts.setDefaults()
+	suite.Require().NoError(opts.validate())
+	assert.Equal(suite.T(), CopyOptions{
+		Readers:    3,
+		Writers:    defaultConcurrency,
+		QueueDepth: 6,
+		ChunkSize:  defaultChunkSize,
+		Alignment:  baseAlignSize,
+	}, opts)
+}
+
+func (suite *IOTestSuite) TestCopyOptionsValidate() {
+	for name, opts := range map[string]CopyOptions{
+		"negative readers":     {Readers: -1},
+		"too many writers":     {Writers: maxConcurrency + 1},
+		"negative queue depth": {QueueDepth: -1},
+		"huge queue depth":     {QueueDepth: maxQueueDepth + 1},
+		"odd alignment":        {Alignment: 3000},
+		"tiny alignment":       {Alignment: 256, ChunkSize: 4096},
+		"negative chunk size":  {ChunkSize: -4096},
+		"huge chunk size":      {ChunkSize: maxChunkSize * 2},
+		"unaligned chunk size": {ChunkSize: 4096 + 512},
+		"unaligned to 8K":      {ChunkSize: 4096, Alignment: 8192},
+	} {
+		opts.setDefaults()
+		assert.ErrorIs(suite.T(), opts.validate(), ErrInvalidOptions, name)
+	}
+}
+
+func (suite *IOTestSuite) TestCopyWithOptions() {
+	srcFile, data := suite.createRandomFile(3*1024*1024 + 777)
+	defer os.Remove(srcFile.Name())
+
+	for _, opts := range []CopyOptions{
+		// a sequential copy for spinning disks
+		{Readers: 1, Writers: 1, QueueDepth: 1, ChunkSize: 512, Alignment: 512},
+		// lots of workers for NVMe
+		{Readers: 32, Writers: 32, QueueDepth: 128, ChunkSize: 64 * 1024},
+		// everything defaulted
+		{},
+	} {
+		dstFile, err := os.CreateTemp("", "dstfile")
+		suite.Require().NoError(err)
+
+		_, err = CopyContext(context.Background(), srcFile, dstFile, opts)
+		suite.Require().NoError(err)
+		dstData := make([]byte, len(data))
+		_, err = dstFile.ReadAt(dstData, 0)
+		suite.Require().NoError(err)
+		assert.Equal(suite.T(), data, dstData)
+
+		dstFile.Close()
+		os.Remove(dstFile.Name())
+	}
+}
@@ -0,0 +1,595 @@
+package io
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	stdio "io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// copier drives the producer/worker pipeline shared by Copy and Write.
+// Producers pull chunks from the scheduler, fill them through fill and queue
+// them on ioQ; workers pwrite the queued chunks to dst. All-zero chunks are
+// handled according to the ZeroPolicy.
+type copier struct {
+	dst     *os.File
+	size    uint64
+	opts    CopyOptions
+	readers int
+	writers int
+	// srcName names the source in errors, empty for sources that are not
+	// files
+	srcName string
+	// srcOffset is where offset 0 of the copy is read from in the source
+	srcOffset uint64
+	// dstOffset is where offset 0 of the copy lands in dst
+	dstOffset uint64
+	// streaming is set when size is only known once fill reports the end
+	// of the source with io.EOF
+	streaming bool
+
+	// fill returns the source data for [offset, offset+count). buf is a
+	// pool buffer of count bytes to read into, nil unless pooled is set.
+	fill   func(offset, count uint64, buf []byte) ([]byte, error)
+	pooled bool
+	pool   *bufferPool
+	// write, when set, stores data chunks in place of the pwrite to dst,
+	// for destinations with a layout of their own such as qcow2
+	write func(offset uint64, data []byte) error
+	// finish, when set, completes the destination once every chunk is in
+	finish func() error
+
+	sched    *scheduler
+	commit   *commitTracker
+	counters counters
+	// stats holds the phase durations of the run
+	stats   Stats
+	zero    *zeroer
+	verify  *verifier
+	journal *journal
+	diff    *differ
+	// durable makes the written data durable, nil with DurabilityNone
+	durable *syncer
+	// rescue, when set, salvages the chunks that fail to read
+	rescue *rescuer
+	// failures are the chunks that failed, the first one stopped the run
+	failures []*IOError
+}
+
+func newCopier(dst *os.File, size uint64, opts CopyOptions) *copier {
+	chunkSize := opts.ChunkSize
+
+	// Calculate the number of chunks based on the chunk size, there is no
+	// point in running more goroutines than chunks
+	numChunks := size / uint64(chunkSize)
+	if size%uint64(chunkSize) != 0 {
+		numChunks++
+	}
+	readers := int(min(uint64(opts.Readers), numChunks))
+	writers := int(min(uint64(opts.Writers), numChunks))
+
+	c := &copier{
+		dst:     dst,
+		size:    size,
+		opts:    opts,
+		readers: readers,
+		writers: writers,
+		sched:   &scheduler{extents: []extent{{offset: 0, length: size}}, chunkSize: uint64(chunkSize)},
+		commit:  &commitTracker{},
+	}
+	if opts.ZeroPolicy != ZeroSkip {
+		c.zero = newZeroer(dst, opts.ZeroPolicy, chunkSize)
+	}
+	if opts.Verify {
+		c.verify = newVerifier(opts.VerifyHash)
+	}
+	c.durable = newSyncer(dst, opts)
+	return c
+}
+
+// setExtents limits the reads to the given sorted, non-overlapping extents.
+// The holes between them are never read: with ZeroSkip they are committed
+// right away, otherwise they are scheduled as zero chunks.
+func (c *copier) setExtents(extents []extent) {
+	var scheduled []extent
+	var offset uint64
+	for _, e := range append(extents, extent{offset: c.size}) {
+		if e.offset > offset {
+			c.counters.holeBytes.Add(e.offset - offset)
+			if c.zero == nil {
+				c.done(offset, e.offset)
+			} else {
+				scheduled = append(scheduled, extent{offset: offset, length: e.offset - offset, hole: true})
+			}
+		}
+		if e.length > 0 {
+			scheduled = append(scheduled, e)
+		}
+		offset = e.end()
+	}
+	c.sched.extents = scheduled
+}
+
+// skipDone drops ranges finished by an earlier run from the schedule and
+// commits them.
+func (c *copier) skipDone(done []extent) {
+	rest, resumed := subtractExtents(c.sched.extents, done)
+	c.counters.resumedBytes.Add(resumed)
+	for _, d := range done {
+		c.commit.commit(d.offset, d.end())
+	}
+	c.sched.extents = rest
+}
+
+// done marks [start, end) as finished on the destination.
+func (c *copier) done(start, end uint64) {
+	c.commit.commit(start, end)
+	if c.journal != nil {
+		c.journal.record(start, end)
+	}
+}
+
+// run transfers every chunk and then completes the destination according to
+// the options.
+func (c *copier) run(ctx context.Context) (*Result, error) {
+	if c.opts.Progress != nil {
+		stop, done := c.startProgress()
+		defer func() {
+			close(stop)
+			<-done
+		}()
+	}
+
+	if c.journal != nil {
+		c.journal.start(c.opts.CheckpointSyncInterval)
+	}
+
+	start := time.Now()
+	err := c.transfer(ctx)
+	c.stats.TransferTime = time.Since(start)
+	finish := time.Now()
+	if c.journal != nil {
+		if jerr := c.journal.close(); err == nil {
+			err = jerr
+		}
+	}
+	if err == nil && c.zero != nil {
+		err = c.zero.extend(c.dstOffset + c.size)
+	} else if err == nil && c.streaming {
+		// ZeroSkip leaves the trailing zero chunks of a stream unwritten,
+		// but its size is only known now and a new file must reach it
+		err = extendFile(c.dst, c.dstOffset+c.size)
+	}
+	if err == nil && c.finish != nil {
+		err = c.finish()
+	}
+	c.stats.FinishTime = time.Since(finish)
+	if err == nil {
+		err = c.durable.flush(c.counters.bytesWritten.Load())
+	}
+	if err == nil && c.verify != nil {
+		verify := time.Now()
+		err = c.verify.run(ctx, c.dst, c.dstOffset, c.size, c.opts.ChunkSize)
+		c.stats.VerifyTime = time.Since(verify)
+	}
+	if err == nil && c.journal != nil {
+		// the copy is complete, a stale journal must not be resumed
+		err = c.journal.remove()
+	}
+	c.stats.Duration = time.Since(start)
+	res := c.result()
+	c.opts.Metrics.observe(res.Stats, err)
+	return res, err
+}
+
+// transfer blocks until every chunk is committed, the first I/O error is hit
+// or ctx is done. Either way all producers and workers have exited and ioQ
+// has been drained when it returns.
+func (c *copier) transfer(ctx context.Context) error {
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if c.pooled && c.readers > 0 {
+		// enough buffers for every producer, queue slot and worker
+		pool, err := newBufferPool(c.readers+c.opts.QueueDepth+c.writers, c.opts.ChunkSize)
+		if err != nil {
+			return err
+		}
+		c.pool = pool
+		defer pool.close()
+	}
+
+	// Create a channel to receive the results
+	ioQ := make(chan Content, c.opts.QueueDepth)
+	// every producer and worker sends at most one error
+	errChan := make(chan error, c.readers+c.writers)
+
+	var producerWG, workerWG sync.WaitGroup
+	for i := 0; i < c.readers; i++ {
+		producerWG.Add(1)
+		go c.ioProducer(runCtx, ioQ, &producerWG, errChan)
+	}
+	for i := 0; i < c.writers; i++ {
+		workerWG.Add(1)
+		go c.ioWorker(runCtx, ioQ, &workerWG, errChan)
+	}
+
+	go func() {
+		producerWG.Wait()
+		close(ioQ)
+	}()
+	workersDone := make(chan struct{})
+	go func() {
+		workerWG.Wait()
+		close(workersDone)
+	}()
+
+	// the first error stops the run, failed chunks are all listed
+	var first error
+	fail := func(err error) {
+		if first == nil {
+			first = err
+		}
+		if ioErr, ok := err.(*IOError); ok {
+			c.failures = append(c.failures, ioErr)
+		}
+	}
+	select {
+	case <-workersDone:
+	case err := <-errChan:
+		fail(err)
+		cancel()
+		<-workersDone
+	}
+
+	// workers are gone, drop whatever the producers still had queued
+	ioQflusher(ioQ)
+
+	// collect the chunks that failed while the run was stopping
+	for len(errChan) > 0 {
+		fail(<-errChan)
+	}
+	if first != nil {
+		return first
+	}
+	if offset := c.commit.committed(); offset < c.size && ctx.Err() != nil {
+		return &CancelError{Offset: offset, Err: ctx.Err()}
+	}
+	return nil
+}
+
+func (c *copier) result() *Result {
+	res := &Result{ZeroPolicy: ZeroSkip}
+	if c.zero != nil {
+		res.ZeroPolicy = c.zero.applied()
+	}
+	if c.verify != nil {
+		res.Digest, res.Mismatches = c.verify.digest, c.verify.mismatches
+	}
+	if c.diff != nil {
+		res.ChunksChanged = c.diff.changedChunks.Load()
+		res.ChunksUnchanged = c.diff.unchangedChunks.Load()
+	}
+	res.Stats = c.stats
+	res.BytesRead = c.counters.bytesRead.Load()
+	res.BytesWritten = c.counters.bytesWritten.Load()
+	res.Chunks = c.counters.chunks.Load()
+	res.ZeroChunks = c.counters.zeroChunks.Load()
+	res.Retries = c.counters.retries.Load()
+	res.ReadTime = time.Duration(c.counters.readTime.Load())
+	res.WriteTime = time.Duration(c.counters.writeTime.Load())
+	res.SyncTime = c.durable.elapsed()
+	res.Failures = c.failures
+	return res
+}
+
+// startProgress runs the progress reporter until stop is closed; done is
+// closed once the final report has been delivered.
+func (c *copier) startProgress() (stop, done chan struct{}) {
+	interval := c.opts.ProgressInterval
+	if interval == 0 {
+		interval = defaultProgressInterval
+	}
+	total := c.size
+	if c.streaming {
+		total = 0
+	}
+	now := time.Now()
+	r := &progressReporter{
+		fn:       c.opts.Progress,
+		interval: interval,
+		total:    total,
+		counters: &c.counters,
+		start:    now,
+		lastTime: now,
+	}
+	stop, done = make(chan struct{}), make(chan struct{})
+	go r.run(stop, done)
+	return stop, done
+}
+
+func (c *copier) ioProducer(ctx context.Context, ioQ chan<- Content, producerWG *sync.WaitGroup, errChan chan<- error) {
+	defer producerWG.Done()
+	var err error
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		chunk, ok := c.sched.next()
+		if !ok {
+			return
+		}
+		obj := Content{offset: chunk.offset, zero: chunk.hole}
+		if !chunk.hole {
+			var buf []byte
+			if c.pool != nil {
+				if buf, err = c.pool.get(ctx); err != nil {
+					return
+				}
+				buf = buf[:chunk.length]
+				obj.pooled = true
+			}
+			obj.buf = buf
+			var data []byte
+			t := startTimer()
+			if c.streaming {
+				// a stream cannot be read again
+				data, err = c.read(ctx, chunk, buf)
+			} else {
+				err = c.retry(ctx, func() (err error) {
+					data, err = c.read(ctx, chunk, buf)
+					return err
+				})
+			}
+			salvaged := false
+			if err != nil && c.rescue != nil && !c.streaming && !errors.Is(err, ctx.Err()) {
+				data, err = c.rescue.salvage(ctx, c, chunk, buf)
+				salvaged = true
+			}
+			t.stop(&c.counters.readTime)
+			if err != nil {
+				c.release(obj)
+				if c.streaming && errors.Is(err, stdio.EOF) || errors.Is(err, ctx.Err()) {
+					return
+				}
+				errChan <- asIOError(OpRead, c.srcName, c.srcOffset+chunk.offset, chunk.length, err)
+				return
+			}
+			// a stream source comes up short at its end
+			obj.buf, chunk.length = data, uint64(len(data))
+			c.counters.bytesRead.Add(chunk.length)
+			// salvaged chunks are written out whole, or the zeros standing
+			// in for bad sectors would leave stale data on dst
+			obj.zero = !salvaged && isZeroChunk(obj.buf)
+		}
+		c.counters.chunks.Add(1)
+		if c.verify != nil {
+			c.verify.record(obj)
+		}
+		if obj.zero {
+			c.counters.zeroChunks.Add(1)
+			c.release(obj)
+			obj.pooled = false
+			// with ZeroSkip zero chunks never touch the destination
+			if c.zero == nil {
+				c.done(chunk.offset, chunk.end())
+				continue
+			}
+			obj.buf = c.zero.buf[:chunk.length]
+		}
+		select {
+		case ioQ <- obj:
+		case <-ctx.Done():
+			c.release(obj)
+			return
+		}
+	}
+}
+
+func (c *copier) ioWorker(ctx context.Context, ioQ <-chan Content, workerWG *sync.WaitGroup, errChan chan<- error) {
+	defer workerWG.Done()
+	var diffBuf []byte
+	if c.diff != nil {
+		diffBuf = make([]byte, c.opts.ChunkSize)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case obj, got := <-ioQ:
+			if !got {
+				return
+			}
+			t := startTimer()
+			err := c.writeChunk(ctx, obj, diffBuf)
+			t.stop(&c.counters.writeTime)
+			c.release(obj)
+			if err != nil && errors.Is(err, ctx.Err()) {
+				// stopped while throttled, run reports why
+				return
+			}
+			if err != nil {
+				errChan <- asIOError(OpWrite, c.dst.Name(), c.dstOffset+obj.offset, uint64(len(obj.buf)), err)
+				return
+			}
+			c.done(obj.offset, obj.offset+uint64(len(obj.buf)))
+			if err := c.durable.periodic(c.counters.bytesWritten.Load()); err != nil {
+				errChan <- err
+				return
+			}
+		}
+	}
+}
+
+// release hands a pooled buffer back once its chunk is done with.
+func (c *copier) release(obj Content) {
+	if obj.pooled {
+		c.pool.put(obj.buf)
+	}
+}
+
+func (c *copier) writeChunk(ctx context.Context, obj Content, diffBuf []byte) error {
+	if c.diff != nil {
+		unchanged, err := c.diff.unchanged(obj, diffBuf)
+		if err != nil || unchanged {
+			return err
+		}
+	}
+	// only chunks that transfer data are throttled
+	if limiter := c.opts.RateLimiter; limiter != nil && (!obj.zero || c.zero.writesZeros()) {
+		if err := limiter.WaitN(ctx, len(obj.buf)); err != nil {
+			return err
+		}
+	}
+	return c.retry(ctx, func() error {
+		return c.writeAttempt(ctx, obj)
+	})
+}
+
+// writeAttempt writes obj once, injecting the write faults of the plan.
+func (c *copier) writeAttempt(ctx context.Context, obj Content) error {
+	data, cut := obj.buf, false
+	fault := c.opts.Faults.hit(FaultWrite, obj.offset, uint64(len(data)))
+	if fault != nil {
+		if err := fault.wait(ctx); err != nil {
+			return err
+		}
+		var n int
+		n, cut = fault.short(len(data))
+		data = data[:n]
+	}
+	if err := c.writeData(obj.offset, data, obj.zero); err != nil {
+		return err
+	}
+	if fault != nil {
+		return fault.err(FaultWrite, cut)
+	}
+	return nil
+}
+
+// writeData writes a chunk, or a zero range, at offset of the copy.
+func (c *copier) writeData(offset uint64, data []byte, zero bool) error {
+	offset += c.dstOffset
+	if zero {
+		return c.zero.zeroRange(offset, uint64(len(data)), &c.counters)
+	}
+	if c.write != nil {
+		if err := c.write(offset, data); err != nil {
+			return err
+		}
+	} else if _, err := PWrite(c.dst, data, len(data), offset); err != nil {
+		return err
+	}
+	c.counters.bytesWritten.Add(uint64(len(data)))
+	return nil
+}
+
+// read fills a chunk through fill, injecting the read faults of the plan.
+func (c *copier) read(ctx context.Context, chunk extent, buf []byte) ([]byte, error) {
+	fault := c.opts.Faults.hit(FaultRead, chunk.offset, chunk.length)
+	if fault == nil {
+		return c.fill(chunk.offset, chunk.length, buf)
+	}
+	if err := fault.wait(ctx); err != nil {
+		return nil, err
+	}
+	n, cut := fault.short(int(chunk.length))
+	if buf != nil {
+		buf = buf[:n]
+	}
+	data, err := c.fill(chunk.offset, uint64(n), buf)
+	if err != nil {
+		return nil, err
+	}
+	if err := fault.err(FaultRead, cut); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func ioQflusher(ioQueue <-chan Content) {
+	for {
+		_, got := <-ioQueue
+		if !got {
+			return
+		}
+	}
+}
+
+// scheduler hands out the chunks of a list of extents in ascending offset
+// order, so that concurrent producers keep the committed prefix of the
+// destination growing.
+type scheduler struct {
+	mu        sync.Mutex
+	extents   []extent
+	cursor    uint64
+	chunkSize uint64
+}
+
+func (s *scheduler) next() (extent, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.extents) > 0 {
+		e := s.extents[0]
+		if s.cursor < e.offset {
+			s.cursor = e.offset
+		}
+		if s.cursor >= e.end() {
+			s.extents = s.extents[1:]
+			continue
+		}
+		end := min(s.cursor+s.chunkSize, e.end())
+		chunk := extent{offset: s.cursor, length: end - s.cursor, hole: e.hole}
+		s.cursor = end
+		return chunk, true
+	}
+	return extent{}, false
+}
+
+// commitTracker records finished ranges, which may complete out of order
+// and overlap, and keeps the offset below which the destination is fully
+// committed.
+type commitTracker struct {
+	mu     sync.Mutex
+	offset uint64
+	// pending holds the ranges past offset, sorted by their start
+	pending []extent
+}
+
+func (t *commitTracker) commit(start, end uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if start > t.offset {
+		i, _ := slices.BinarySearchFunc(t.pending, start, func(e extent, start uint64) int {
+			return cmp.Compare(e.offset, start)
+		})
+		t.pending = slices.Insert(t.pending, i, extent{offset: start, length: end - start})
+		return
+	}
+	t.offset = max(t.offset, end)
+	for len(t.pending) > 0 && t.pending[0].offset <= t.offset {
+		t.offset = max(t.offset, t.pending[0].end())
+		t.pending = t.pending[1:]
+	}
+}
+
+func (t *commitTracker) committed() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.offset
+}
+
+// finished returns the sorted ranges committed so far.
+func (t *commitTracker) finished() []extent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var done []extent
+	if t.offset > 0 {
+		done = append(done, extent{length: t.offset})
+	}
+	return mergeExtents(append(done, t.pending...))
+}
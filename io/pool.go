@@ -0,0 +1,78 @@
+package io
+
+import (
+	"context"
+	"unsafe"
+)
+
+// bufferPool hands out chunk-sized, page-aligned buffers carved out of a
+// single slab. Buffers travel from the producers through ioQ to the workers
+// and back, so the data path allocates nothing per chunk and reads and
+// writes go straight to the buffers, even with O_DIRECT.
+type bufferPool struct {
+	slab []byte
+	free chan []byte
+}
+
+func newBufferPool(count, size int) (*bufferPool, error) {
+	// keep every buffer page aligned, whatever the chunk size
+	stride := (size + baseAlignSize - 1) / baseAlignSize * baseAlignSize
+	slab, err := allocAligned(count * stride)
+	if err != nil {
+		return nil, err
+	}
+	p := &bufferPool{slab: slab, free: make(chan []byte, count)}
+	for i := 0; i < count; i++ {
+		start := i * stride
+		p.free <- slab[start : start+size : start+size]
+	}
+	return p, nil
+}
+
+// get blocks until a buffer is free or ctx is done.
+func (p *bufferPool) get(ctx context.Context) ([]byte, error) {
+	select {
+	case buf := <-p.free:
+		return buf, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (p *bufferPool) put(buf []byte) {
+	p.free <- buf[:cap(buf)]
+}
+
+// close releases the slab. No buffer may be used afterwards.
+func (p *bufferPool) close() {
+	freeAligned(p.slab)
+}
+
+// isZeroChunk reports whether buf only holds zeros. It checks a machine word
+// at a time, four words per iteration, once buf is word aligned.
+func isZeroChunk(buf []byte) bool {
+	for len(buf) > 0 && uintptr(unsafe.Pointer(unsafe.SliceData(buf)))%8 != 0 {
+		if buf[0] != 0 {
+			return false
+		}
+		buf = buf[1:]
+	}
+	words := unsafe.Slice((*uint64)(unsafe.Pointer(unsafe.SliceData(buf))), len(buf)/8)
+	for len(words) >= 4 {
+		if words[0]|words[1]|words[2]|words[3] != 0 {
+			return false
+		}
+		words = words[4:]
+	}
+	for _, w := range words {
+		if w != 0 {
+			return false
+		}
+	}
+	for _, b := range buf[len(buf)/8*8:] {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
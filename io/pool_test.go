@@ -0,0 +1,50 @@
+package io
+
+import (
+	"context"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *IOTestSuite) TestIsZeroChunk() {
+	buf := make([]byte, 4096+64)
+	// every start and length, so that the unaligned head and tail are hit
+	for start := 0; start < 16; start++ {
+		for _, length := range []int{0, 1, 7, 8, 31, 32, 33, 4096} {
+			chunk := buf[start : start+length]
+			assert.True(suite.T(), isZeroChunk(chunk))
+			for i := range chunk {
+				chunk[i] = 1
+				assert.False(suite.T(), isZeroChunk(chunk), "start %d length %d byte %d", start, length, i)
+				chunk[i] = 0
+			}
+		}
+	}
+}
+
+func (suite *IOTestSuite) TestBufferPool() {
+	pool, err := newBufferPool(3, 6000)
+	suite.Require().NoError(err)
+	defer pool.close()
+
+	var bufs [][]byte
+	for i := 0; i < 3; i++ {
+		buf, err := pool.get(context.Background())
+		suite.Require().NoError(err)
+		assert.Len(suite.T(), buf, 6000)
+		assert.True(suite.T(), isAligned(buf))
+		bufs = append(bufs, buf)
+	}
+
+	// the pool is exhausted until a buffer is handed back
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = pool.get(ctx)
+	assert.ErrorIs(suite.T(), err, context.Canceled)
+
+	pool.put(bufs[1][:100])
+	buf, err := pool.get(context.Background())
+	suite.Require().NoError(err)
+	assert.Len(suite.T(), buf, 6000)
+	assert.Equal(suite.T(), &bufs[1][0], &buf[0])
+}
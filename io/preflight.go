@@ -0,0 +1,304 @@
+package io
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/prometheus/procfs"
+	"golang.org/x/sys/unix"
+)
+
+// preflight checks that the destination can take the copy before anything is
+// written to it. src is nil when the source is not a file.
+func (c *copier) preflight(src *os.File, srcOff uint64) error {
+	dstInfo, err := c.dst.Stat()
+	if err != nil {
+		return err
+	}
+	dstDevice := isBlockDevice(dstInfo)
+	if src != nil {
+		if err := checkSameFile(src, srcOff, c.dst, c.dstOffset, c.size); err != nil {
+			return err
+		}
+	}
+	if dstDevice {
+		if err := checkNotMounted(c.dst, dstInfo); err != nil {
+			return err
+		}
+		if err := checkSectorSize(src, c.dst, c.dstOffset, c.opts.Alignment); err != nil {
+			return err
+		}
+	}
+	if c.streaming {
+		// nothing is known about the size
+		return nil
+	}
+	return checkCapacity(c.dst, dstInfo, c.dstOffset+c.size, c.dataBytes())
+}
+
+// dataBytes is the most the scheduled chunks can write to the destination.
+func (c *copier) dataBytes() uint64 {
+	var data uint64
+	for _, e := range c.sched.extents {
+		if !e.hole || c.opts.ZeroPolicy == ZeroWrite {
+			data += e.length
+		}
+	}
+	return data
+}
+
+// checkSameFile rejects copying a range of a file onto itself.
+func checkSameFile(src *os.File, srcOff uint64, dst *os.File, dstOff uint64, length uint64) error {
+	srcInfo, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	dstInfo, err := dst.Stat()
+	if err != nil {
+		return err
+	}
+	same := os.SameFile(srcInfo, dstInfo)
+	if !same && isBlockDevice(srcInfo) && isBlockDevice(dstInfo) {
+		// two nodes of the same device
+		same = rdev(srcInfo) == rdev(dstInfo)
+	}
+	// disjoint ranges of the same file are fine
+	if same && srcOff < dstOff+length && dstOff < srcOff+length {
+		return &PreflightError{Path: dst.Name(), Err: ErrSameFile,
+			Reason: fmt.Sprintf("it is the same file as %s", src.Name())}
+	}
+	return nil
+}
+
+// checkNotMounted rejects a device that is mounted or claimed by another
+// device, such as a device mapper (LVM, dm-crypt) or md RAID volume, or that
+// has a partition that is.
+func checkNotMounted(dst *os.File, info os.FileInfo) error {
+	dev := rdev(info)
+	sysDir := "/sys/dev/block/" + devNumber(dev)
+	if holder := findHolder(sysDir); holder != "" {
+		return &PreflightError{Path: dst.Name(), Err: ErrMounted,
+			Reason: fmt.Sprintf("device %s is held by %s", devNumber(dev), holder)}
+	}
+	devs := map[string]bool{devNumber(dev): true}
+	partitions, _ := filepath.Glob(sysDir + "/*/dev")
+	for _, partition := range partitions {
+		if data, err := os.ReadFile(partition); err == nil {
+			devs[strings.TrimSpace(string(data))] = true
+		}
+	}
+	mounts, err := procfs.GetMounts()
+	if err != nil {
+		return fmt.Errorf("error reading mounts: %w", err)
+	}
+	if mount := findMount(mounts, devs); mount != nil {
+		return &PreflightError{Path: dst.Name(), Err: ErrMounted,
+			Reason: fmt.Sprintf("device %s is mounted on %s", mount.MajorMinorVer, mount.MountPoint)}
+	}
+	return nil
+}
+
+// findHolder returns the name of the first device holding the block device
+// at sysDir in sysfs, or one of its partitions, "" when there is none.
+func findHolder(sysDir string) string {
+	for _, pattern := range []string{"/holders/*", "/*/holders/*"} {
+		if holders, _ := filepath.Glob(sysDir + pattern); len(holders) > 0 {
+			return filepath.Base(holders[0])
+		}
+	}
+	return ""
+}
+
+// findMount returns the first mount of one of the "major:minor" devices.
+func findMount(mounts []*procfs.MountInfo, devs map[string]bool) *procfs.MountInfo {
+	for _, mount := range mounts {
+		if devs[mount.MajorMinorVer] {
+			return mount
+		}
+	}
+	return nil
+}
+
+// checkSectorSize makes sure that the chunks line up with the logical
+// sectors of a dst device, and that a device image keeps its sector size, as
+// partition tables address sectors.
+func checkSectorSize(src *os.File, dst *os.File, dstOff uint64, alignment int) error {
+	dstSector, _, err := blockSizes(dst)
+	if err != nil {
+		return err
+	}
+	if alignment%dstSector != 0 || dstOff%uint64(dstSector) != 0 {
+		return &PreflightError{Path: dst.Name(), Err: ErrSectorSize,
+			Reason: fmt.Sprintf("alignment %d and offset %d must be multiples of the %d byte sectors", alignment, dstOff, dstSector)}
+	}
+	if src == nil {
+		return nil
+	}
+	if info, err := src.Stat(); err != nil || !isBlockDevice(info) {
+		return err
+	}
+	srcSector, _, err := blockSizes(src)
+	if err != nil {
+		return err
+	}
+	if srcSector != dstSector {
+		return &PreflightError{Path: dst.Name(), Err: ErrSectorSize,
+			Reason: fmt.Sprintf("%d byte sectors, but %s has %d byte sectors", dstSector, src.Name(), srcSector)}
+	}
+	return nil
+}
+
+// checkCapacity makes sure that a dst device reaches end, and that a dst file
+// can grow to end and has room for data more bytes. Files are held to the
+// RLIMIT_FSIZE limit of the process, the free space of their filesystem and
+// the disk quotas of their owner, group and project.
+func checkCapacity(dst *os.File, info os.FileInfo, end, data uint64) error {
+	if isBlockDevice(info) {
+		size, err := getSourceVolSize(dst)
+		if err != nil {
+			return err
+		}
+		if end > size {
+			return &PreflightError{Path: dst.Name(), Err: ErrNoSpace,
+				Reason: fmt.Sprintf("the copy ends at %d but the device holds %d bytes", end, size)}
+		}
+		return nil
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	var limit unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_FSIZE, &limit); err != nil {
+		return err
+	}
+	if limit.Cur != unix.RLIM_INFINITY && end > limit.Cur && end > uint64(info.Size()) {
+		return &PreflightError{Path: dst.Name(), Err: ErrNoSpace,
+			Reason: fmt.Sprintf("the copy ends at %d, past the file size limit of %d bytes", end, limit.Cur)}
+	}
+
+	var fs unix.Statfs_t
+	if err := unix.Fstatfs(int(dst.Fd()), &fs); err != nil {
+		return err
+	}
+	// blocks the file already holds are overwritten in place
+	var allocated uint64
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		allocated = uint64(st.Blocks) * 512
+	}
+	available := fs.Bavail*uint64(fs.Bsize) + allocated
+	if data > available {
+		return &PreflightError{Path: dst.Name(), Err: ErrNoSpace,
+			Reason: fmt.Sprintf("the copy writes up to %d bytes but %d are available", data, available)}
+	}
+	return checkQuotas(dst, info, data, allocated)
+}
+
+// Quota commands, types and flags from linux/quota.h and linux/fs.h.
+const (
+	qGetQuota       = 0x800007
+	qifBlockLimits  = 1
+	qifSpace        = 2
+	qifBlockSize    = 1024
+	fsIocFsGetXattr = 0x801c581f
+	usrQuota        = 0
+	grpQuota        = 1
+	prjQuota        = 2
+)
+
+// ifDqblk mirrors struct if_dqblk from linux/quota.h.
+type ifDqblk struct {
+	BHardLimit uint64
+	BSoftLimit uint64
+	CurSpace   uint64
+	IHardLimit uint64
+	ISoftLimit uint64
+	CurInodes  uint64
+	BTime      uint64
+	ITime      uint64
+	Valid      uint32
+	_          uint32
+}
+
+// fsxattr mirrors struct fsxattr from linux/fs.h.
+type fsxattr struct {
+	XFlags     uint32
+	ExtSize    uint32
+	NExtents   uint32
+	ProjID     uint32
+	CowExtSize uint32
+	_          [8]byte
+}
+
+// quotaID is a user, group or project that a file is accounted to.
+type quotaID struct {
+	kind uint32
+	name string
+	id   uint32
+}
+
+// room returns how many more bytes the quota allows, and false when it sets
+// no block limit.
+func (q *ifDqblk) room() (uint64, bool) {
+	if q.Valid&(qifBlockLimits|qifSpace) != qifBlockLimits|qifSpace || q.BHardLimit == 0 {
+		return 0, false
+	}
+	limit := q.BHardLimit * qifBlockSize
+	if q.CurSpace >= limit {
+		return 0, true
+	}
+	return limit - q.CurSpace, true
+}
+
+// checkQuotas makes sure that the quotas of the owner, the group and the
+// project of a dst file have room for data more bytes, of which allocated
+// are overwritten in place. They are read with quotactl_fd(2), through dst
+// itself. Kernels before 5.14, filesystems without quotas and quotas that
+// are off are skipped, and so are the quotas of other users, which only root
+// may read.
+func checkQuotas(dst *os.File, info os.FileInfo, data, allocated uint64) error {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	quotas := []quotaID{{usrQuota, "user", st.Uid}, {grpQuota, "group", st.Gid}}
+	var attr fsxattr
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, dst.Fd(), fsIocFsGetXattr, uintptr(unsafe.Pointer(&attr))); errno == 0 {
+		quotas = append(quotas, quotaID{prjQuota, "project", attr.ProjID})
+	}
+
+	for _, quota := range quotas {
+		var q ifDqblk
+		_, _, errno := unix.Syscall6(unix.SYS_QUOTACTL_FD, dst.Fd(), uintptr(qGetQuota<<8|quota.kind),
+			uintptr(quota.id), uintptr(unsafe.Pointer(&q)), 0, 0)
+		switch errno {
+		case 0:
+		case unix.ENOSYS, unix.ESRCH, unix.EPERM:
+			continue
+		default:
+			return fmt.Errorf("error reading the %s quota of %s: %w", quota.name, dst.Name(), errno)
+		}
+		if room, limited := q.room(); limited && data > room+allocated {
+			return &PreflightError{Path: dst.Name(), Err: ErrNoSpace,
+				Reason: fmt.Sprintf("the copy writes up to %d bytes but the quota of %s %d leaves %d",
+					data, quota.name, quota.id, room+allocated)}
+		}
+	}
+	return nil
+}
+
+func rdev(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return st.Rdev
+	}
+	return 0
+}
+
+// devNumber formats a device number as "major:minor".
+func devNumber(dev uint64) string {
+	return fmt.Sprintf("%d:%d", unix.Major(dev), unix.Minor(dev))
+}
@@ -0,0 +1,115 @@
+package io
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/prometheus/procfs"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func (suite *IOTestSuite) TestPreflightSameFile() {
+	srcFile, data := suite.createRandomFile(64 * 1024)
+	defer os.Remove(srcFile.Name())
+
+	_, err := CopyContext(context.Background(), srcFile, srcFile, CopyOptions{})
+	var preflightErr *PreflightError
+	suite.Require().ErrorAs(err, &preflightErr)
+	assert.ErrorIs(suite.T(), err, ErrSameFile)
+	assert.Equal(suite.T(), srcFile.Name(), preflightErr.Path)
+
+	// another handle of the same file
+	other, err := os.OpenFile(srcFile.Name(), os.O_RDWR, 0)
+	suite.Require().NoError(err)
+	defer other.Close()
+	_, err = CopyRange(context.Background(), srcFile, 0, other, 16*1024, 32*1024, CopyOptions{})
+	assert.ErrorIs(suite.T(), err, ErrSameFile)
+
+	// disjoint ranges of one file can be copied
+	_, err = CopyRange(context.Background(), srcFile, 0, other, 32*1024, 32*1024, CopyOptions{})
+	suite.Require().NoError(err)
+	copied, err := os.ReadFile(srcFile.Name())
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), data[:32*1024], copied[32*1024:])
+}
+
+func (suite *IOTestSuite) TestPreflightFileSizeLimit() {
+	srcFile, _ := suite.createRandomFile(256 * 1024)
+	defer os.Remove(srcFile.Name())
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	var limit unix.Rlimit
+	suite.Require().NoError(unix.Getrlimit(unix.RLIMIT_FSIZE, &limit))
+	lowered := limit
+	lowered.Cur = 128 * 1024
+	suite.Require().NoError(unix.Setrlimit(unix.RLIMIT_FSIZE, &lowered))
+	defer unix.Setrlimit(unix.RLIMIT_FSIZE, &limit) //nolint:errcheck
+
+	_, err = CopyContext(context.Background(), srcFile, dstFile, CopyOptions{})
+	assert.ErrorIs(suite.T(), err, ErrNoSpace)
+	info, err := dstFile.Stat()
+	suite.Require().NoError(err)
+	assert.Zero(suite.T(), info.Size())
+}
+
+func (suite *IOTestSuite) TestPreflightCapacity() {
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+	info, err := dstFile.Stat()
+	suite.Require().NoError(err)
+
+	var fs unix.Statfs_t
+	suite.Require().NoError(unix.Fstatfs(int(dstFile.Fd()), &fs))
+	free := fs.Bavail * uint64(fs.Bsize)
+	assert.NoError(suite.T(), checkCapacity(dstFile, info, free, free))
+	err = checkCapacity(dstFile, info, free+1<<30, free+1<<30)
+	assert.ErrorIs(suite.T(), err, ErrNoSpace)
+}
+
+func (suite *IOTestSuite) TestPreflightMounts() {
+	mounts := []*procfs.MountInfo{
+		{MajorMinorVer: "0:23", MountPoint: "/proc"},
+		{MajorMinorVer: "8:1", MountPoint: "/boot"},
+	}
+	assert.Nil(suite.T(), findMount(mounts, map[string]bool{"8:0": true, "8:2": true}))
+	mount := findMount(mounts, map[string]bool{"8:0": true, "8:1": true})
+	suite.Require().NotNil(mount)
+	assert.Equal(suite.T(), "/boot", mount.MountPoint)
+}
+
+func (suite *IOTestSuite) TestPreflightQuota() {
+	valid := uint32(qifBlockLimits | qifSpace)
+	_, limited := (&ifDqblk{Valid: valid}).room()
+	assert.False(suite.T(), limited)
+	room, limited := (&ifDqblk{Valid: valid, BHardLimit: 1024, CurSpace: 256 * 1024}).room()
+	assert.True(suite.T(), limited)
+	assert.Equal(suite.T(), uint64(768*1024), room)
+	room, limited = (&ifDqblk{Valid: valid, BHardLimit: 1024, CurSpace: 2 << 20}).room()
+	assert.True(suite.T(), limited)
+	assert.Zero(suite.T(), room)
+
+	// temporary directories rarely have quotas, their absence is no error
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+	info, err := dstFile.Stat()
+	suite.Require().NoError(err)
+	assert.NoError(suite.T(), checkQuotas(dstFile, info, 1<<20, 0))
+}
+
+func (suite *IOTestSuite) TestPreflightHolders() {
+	// /sys/dev/block/8:0 of a disk with an LVM physical volume on sda2
+	sysDir := suite.T().TempDir()
+	suite.Require().NoError(os.MkdirAll(filepath.Join(sysDir, "holders"), 0755))
+	suite.Require().NoError(os.MkdirAll(filepath.Join(sysDir, "sda1", "holders"), 0755))
+	assert.Empty(suite.T(), findHolder(sysDir))
+
+	suite.Require().NoError(os.MkdirAll(filepath.Join(sysDir, "sda2", "holders", "dm-0"), 0755))
+	assert.Equal(suite.T(), "dm-0", findHolder(sysDir))
+	assert.Equal(suite.T(), "dm-0", findHolder(filepath.Join(sysDir, "sda2")))
+}
@@ -0,0 +1,126 @@
+package io
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const defaultProgressInterval = time.Second
+
+// Progress is a snapshot of a running Copy or Write.
+type Progress struct {
+	// Total is the number of bytes the copy has to go through, 0 when it is
+	// not known in advance as with WriteFromReader.
+	Total uint64
+	// BytesRead is the number of source bytes read so far.
+	BytesRead uint64
+	// BytesWritten is the number of bytes written to the destination so far,
+	// including zeros written by ZeroWrite.
+	BytesWritten uint64
+	// ZeroChunks is the number of all-zero chunks found so far. They are
+	// skipped or zeroed on the destination according to the ZeroPolicy.
+	ZeroChunks uint64
+	// HoleBytes is the number of source bytes skipped as holes without
+	// being read.
+	HoleBytes uint64
+	// ResumedBytes is the number of source bytes copied by an earlier,
+	// interrupted run and skipped by ResumeCopy.
+	ResumedBytes uint64
+	// Elapsed is the time since the copy started.
+	Elapsed time.Duration
+	// Throughput is the read rate in bytes per second since the previous
+	// report.
+	Throughput float64
+	// Pass is the Wipe pass being run, counted from 1, and Passes the
+	// number of passes. Both are 0 for copies.
+	Pass   int
+	Passes int
+}
+
+// ProgressFunc receives progress reports. It is called from a single
+// goroutine, so it never runs concurrently with itself.
+type ProgressFunc func(Progress)
+
+// Percent returns how much of the copy is done, from 0 to 100. It returns 0
+// when the total is not known.
+func (p Progress) Percent() float64 {
+	if p.Total == 0 {
+		return 0
+	}
+	return float64(p.done()) * 100 / float64(p.Total)
+}
+
+// ETA estimates the remaining time from the current throughput. It returns
+// 0 when the throughput is not known yet.
+func (p Progress) ETA() time.Duration {
+	done := p.done()
+	if p.Throughput <= 0 || done >= p.Total {
+		return 0
+	}
+	return time.Duration(float64(p.Total-done) / p.Throughput * float64(time.Second))
+}
+
+func (p Progress) done() uint64 {
+	return p.BytesRead + p.HoleBytes + p.ResumedBytes
+}
+
+// counters are updated by producers and workers as chunks flow through the
+// pipeline.
+type counters struct {
+	bytesRead    atomic.Uint64
+	bytesWritten atomic.Uint64
+	zeroChunks   atomic.Uint64
+	holeBytes    atomic.Uint64
+	resumedBytes atomic.Uint64
+	retries      atomic.Uint64
+	chunks       atomic.Uint64
+	// readTime and writeTime are in nanoseconds
+	readTime  atomic.Int64
+	writeTime atomic.Int64
+}
+
+// progressReporter calls fn every interval until stop is closed, then sends
+// one final report so callers always see the end state.
+type progressReporter struct {
+	fn       ProgressFunc
+	interval time.Duration
+	total    uint64
+	counters *counters
+	start    time.Time
+
+	lastRead uint64
+	lastTime time.Time
+}
+
+func (r *progressReporter) run(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			r.report(time.Now())
+			return
+		case now := <-ticker.C:
+			r.report(now)
+		}
+	}
+}
+
+func (r *progressReporter) report(now time.Time) {
+	read := r.counters.bytesRead.Load()
+	p := Progress{
+		Total:        r.total,
+		BytesRead:    read,
+		BytesWritten: r.counters.bytesWritten.Load(),
+		ZeroChunks:   r.counters.zeroChunks.Load(),
+		HoleBytes:    r.counters.holeBytes.Load(),
+		ResumedBytes: r.counters.resumedBytes.Load(),
+		Elapsed:      now.Sub(r.start),
+	}
+	if since := now.Sub(r.lastTime); since > 0 {
+		p.Throughput = float64(read-r.lastRead) / since.Seconds()
+	}
+	r.lastRead, r.lastTime = read, now
+	r.fn(p)
+}
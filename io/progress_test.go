@@ -0,0 +1,86 @@
+package io
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *IOTestSuite) TestCopyProgress() {
+	srcFile, data := suite.createRandomFile(1024 * 1024)
+	defer os.Remove(srcFile.Name())
+
+	// with two zero chunks
+	clear(data[4096:8192])
+	clear(data[12288:16384])
+	_, err := srcFile.WriteAt(data, 0)
+	suite.Require().NoError(err)
+
+	// Create a temporary dstFile for testing
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	var mu sync.Mutex
+	var reports []Progress
+	opts := CopyOptions{
+		ChunkSize:        4096,
+		ProgressInterval: time.Millisecond,
+		Progress: func(p Progress) {
+			mu.Lock()
+			defer mu.Unlock()
+			reports = append(reports, p)
+		},
+	}
+	_, err = CopyContext(context.Background(), srcFile, dstFile, opts)
+	suite.Require().NoError(err)
+
+	// The final report always reflects the finished copy
+	mu.Lock()
+	defer mu.Unlock()
+	suite.Require().NotEmpty(reports)
+	last := reports[len(reports)-1]
+	assert.Equal(suite.T(), uint64(len(data)), last.Total)
+	assert.Equal(suite.T(), uint64(len(data)), last.BytesRead)
+	assert.Equal(suite.T(), uint64(len(data)-2*4096), last.BytesWritten)
+	assert.Equal(suite.T(), uint64(2), last.ZeroChunks)
+	assert.Equal(suite.T(), float64(100), last.Percent())
+	assert.Equal(suite.T(), time.Duration(0), last.ETA())
+	for i := 1; i < len(reports); i++ {
+		assert.GreaterOrEqual(suite.T(), reports[i].BytesRead, reports[i-1].BytesRead)
+	}
+}
+
+func (suite *IOTestSuite) TestProgressETA() {
+	p := Progress{Total: 1000, BytesRead: 250, Throughput: 50}
+	assert.Equal(suite.T(), float64(25), p.Percent())
+	assert.Equal(suite.T(), 15*time.Second, p.ETA())
+}
+
+func (suite *IOTestSuite) TestStreamProgress() {
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	data := make([]byte, 256*1024)
+	_, err = rand.Read(data)
+	suite.Require().NoError(err)
+
+	var reports []Progress
+	opts := CopyOptions{ChunkSize: 4096, Progress: func(p Progress) { reports = append(reports, p) }}
+	_, err = WriteFromReader(context.Background(), dstFile, bytes.NewReader(data), opts)
+	suite.Require().NoError(err)
+
+	// a stream has no known total to report a percentage of
+	suite.Require().NotEmpty(reports)
+	last := reports[len(reports)-1]
+	assert.Equal(suite.T(), uint64(0), last.Total)
+	assert.Equal(suite.T(), uint64(len(data)), last.BytesRead)
+	assert.Equal(suite.T(), float64(0), last.Percent())
+	assert.Equal(suite.T(), time.Duration(0), last.ETA())
+}
@@ -0,0 +1,340 @@
+package io
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	stdio "io"
+	"os"
+	"sync"
+)
+
+const (
+	qcow2Magic = 0x514649fb
+	// qcow2OffsetMask extracts host offsets from L1 and L2 entries
+	qcow2OffsetMask = 0x00fffffffffffe00
+	// qcow2Compressed flags an L2 entry describing a compressed cluster
+	qcow2Compressed = 1 << 62
+	// qcow2ZeroFlag flags an L2 entry of a cluster reading as zeros, v3 only
+	qcow2ZeroFlag = 1
+	// qcow2IncompatDirty is the only incompatible feature a reader can
+	// ignore, it only means the refcounts may be stale
+	qcow2IncompatDirty = 1
+	qcow2V2HeaderSize  = 72
+	qcow2V3HeaderSize  = 104
+	// qcow2MaxL1Size is the largest L1 table qemu accepts, in bytes
+	qcow2MaxL1Size = 32 * 1024 * 1024
+	// l2CacheTables bounds the L2 tables kept in memory, each one maps
+	// clusterSize/8 clusters
+	l2CacheTables = 32
+)
+
+// qcow2Header is the fixed part of a qcow2 header, as laid out on disk in
+// big endian.
+type qcow2Header struct {
+	Magic                 uint32
+	Version               uint32
+	BackingFileOffset     uint64
+	BackingFileSize       uint32
+	ClusterBits           uint32
+	Size                  uint64
+	CryptMethod           uint32
+	L1Size                uint32
+	L1TableOffset         uint64
+	RefcountTableOffset   uint64
+	RefcountTableClusters uint32
+	NbSnapshots           uint32
+	SnapshotsOffset       uint64
+	// version 3 only
+	IncompatibleFeatures uint64
+	CompatibleFeatures   uint64
+	AutoclearFeatures    uint64
+	RefcountOrder        uint32
+	HeaderLength         uint32
+}
+
+// Qcow2Image reads the guest view of a qcow2 image, so that it can be
+// copied straight to a raw device with CopyFrom. Versions 2 and 3 are
+// supported, including zlib compressed and zero clusters. Images with a
+// backing file, encryption, an external data file or extended L2 entries
+// are rejected with ErrUnsupportedImage. It is safe for concurrent use.
+type Qcow2Image struct {
+	r      stdio.ReaderAt
+	closer stdio.Closer
+
+	version     uint32
+	clusterBits uint32
+	clusterSize uint64
+	size        uint64
+	l1          []uint64
+
+	mu      sync.Mutex
+	l2Cache map[uint64][]uint64
+}
+
+// OpenQcow2 opens the qcow2 image at path. The file must not be opened with
+// O_DIRECT since clusters sit at arbitrary offsets.
+func OpenQcow2(path string) (*Qcow2Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	img, err := NewQcow2Image(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	img.closer = f
+	return img, nil
+}
+
+// NewQcow2Image reads the qcow2 image in r. Close does not close r.
+func NewQcow2Image(r stdio.ReaderAt) (*Qcow2Image, error) {
+	buf := make([]byte, qcow2V3HeaderSize)
+	if n, err := r.ReadAt(buf, 0); n < qcow2V2HeaderSize {
+		return nil, fmt.Errorf("%w: reading qcow2 header: %v", ErrInvalidImage, shortReadErr(err))
+	}
+	var h qcow2Header
+	if err := binary.Read(bytes.NewReader(buf), binary.BigEndian, &h); err != nil {
+		return nil, err
+	}
+
+	if h.Magic != qcow2Magic {
+		return nil, fmt.Errorf("%w: not a qcow2 image", ErrInvalidImage)
+	}
+	switch h.Version {
+	case 2:
+	case 3:
+		if h.HeaderLength < qcow2V3HeaderSize {
+			return nil, fmt.Errorf("%w: qcow2 v3 header length %d is too short", ErrInvalidImage, h.HeaderLength)
+		}
+		if h.IncompatibleFeatures&^qcow2IncompatDirty != 0 {
+			return nil, fmt.Errorf("%w: qcow2 incompatible features %#x", ErrUnsupportedImage, h.IncompatibleFeatures)
+		}
+	default:
+		return nil, fmt.Errorf("%w: qcow2 version %d", ErrUnsupportedImage, h.Version)
+	}
+	if h.ClusterBits < 9 || h.ClusterBits > 21 {
+		return nil, fmt.Errorf("%w: qcow2 cluster bits %d", ErrInvalidImage, h.ClusterBits)
+	}
+	if h.BackingFileOffset != 0 {
+		return nil, fmt.Errorf("%w: qcow2 images with a backing file", ErrUnsupportedImage)
+	}
+	if h.CryptMethod != 0 {
+		return nil, fmt.Errorf("%w: encrypted qcow2 images", ErrUnsupportedImage)
+	}
+
+	img := &Qcow2Image{
+		r:           r,
+		version:     h.Version,
+		clusterBits: h.ClusterBits,
+		clusterSize: 1 << h.ClusterBits,
+		size:        h.Size,
+		l2Cache:     map[uint64][]uint64{},
+	}
+	if uint64(h.L1Size)*8 > qcow2MaxL1Size {
+		return nil, fmt.Errorf("%w: qcow2 L1 table of %d entries is too large", ErrInvalidImage, h.L1Size)
+	}
+	// every L2 table maps clusterSize/8 clusters
+	spanned := img.l2Entries() << h.ClusterBits
+	if need := (h.Size + spanned - 1) / spanned; uint64(h.L1Size) < need {
+		return nil, fmt.Errorf("%w: qcow2 L1 table has %d entries, %d needed", ErrInvalidImage, h.L1Size, need)
+	}
+	var err error
+	if img.l1, err = readTable(r, h.L1TableOffset, uint64(h.L1Size)); err != nil {
+		return nil, fmt.Errorf("error reading qcow2 L1 table: %w", err)
+	}
+	return img, nil
+}
+
+// Size returns the virtual size of the image.
+func (q *Qcow2Image) Size() uint64 {
+	return q.size
+}
+
+// Extents returns the ranges of the image backed by data clusters. Clusters
+// that are unallocated or flagged as zero read as zeros and are left out.
+func (q *Qcow2Image) Extents() ([]Extent, error) {
+	var extents []Extent
+	for i, l1Entry := range q.l1 {
+		l2Offset := l1Entry & qcow2OffsetMask
+		if l2Offset == 0 {
+			continue
+		}
+		table, err := q.l2Table(l2Offset)
+		if err != nil {
+			return nil, err
+		}
+		for j, entry := range table {
+			offset := (uint64(i)*q.l2Entries() + uint64(j)) << q.clusterBits
+			if offset >= q.size {
+				return extents, nil
+			}
+			if !q.hasData(entry) {
+				continue
+			}
+			length := min(q.clusterSize, q.size-offset)
+			if n := len(extents); n > 0 && extents[n-1].Offset+extents[n-1].Length == offset {
+				extents[n-1].Length += length
+				continue
+			}
+			extents = append(extents, Extent{Offset: offset, Length: length})
+		}
+	}
+	return extents, nil
+}
+
+// ReadAt reads the guest data at off, cluster by cluster.
+func (q *Qcow2Image) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	var n int
+	for n < len(p) && uint64(off) < q.size {
+		pos := uint64(off)
+		inCluster := pos & (q.clusterSize - 1)
+		count := min(uint64(len(p)-n), q.clusterSize-inCluster, q.size-pos)
+		if err := q.readCluster(p[n:n+int(count)], pos); err != nil {
+			return n, err
+		}
+		n += int(count)
+		off += int64(count)
+	}
+	if n < len(p) {
+		return n, stdio.EOF
+	}
+	return n, nil
+}
+
+// Close closes the file opened by OpenQcow2.
+func (q *Qcow2Image) Close() error {
+	if q.closer != nil {
+		return q.closer.Close()
+	}
+	return nil
+}
+
+func (q *Qcow2Image) l2Entries() uint64 {
+	return q.clusterSize / 8
+}
+
+func (q *Qcow2Image) hasData(entry uint64) bool {
+	if entry&qcow2Compressed != 0 {
+		return true
+	}
+	if q.version >= 3 && entry&qcow2ZeroFlag != 0 {
+		return false
+	}
+	return entry&qcow2OffsetMask != 0
+}
+
+// readCluster fills buf, which does not cross a cluster boundary, with the
+// guest data at pos.
+func (q *Qcow2Image) readCluster(buf []byte, pos uint64) error {
+	entry, err := q.l2Entry(pos)
+	if err != nil {
+		return err
+	}
+	inCluster := pos & (q.clusterSize - 1)
+	switch {
+	case !q.hasData(entry):
+		clear(buf)
+	case entry&qcow2Compressed != 0:
+		cluster, err := q.decompress(entry)
+		if err != nil {
+			return err
+		}
+		copy(buf, cluster[inCluster:])
+	default:
+		host := entry&qcow2OffsetMask + inCluster
+		if n, err := q.r.ReadAt(buf, int64(host)); n < len(buf) {
+			return fmt.Errorf("error reading qcow2 cluster at %d: %w", host, shortReadErr(err))
+		}
+	}
+	return nil
+}
+
+// decompress inflates the compressed cluster described by entry. The host
+// offset takes the low 62-(clusterBits-8) bits, the rest hold the number of
+// 512 byte sectors the compressed data spans, minus one.
+func (q *Qcow2Image) decompress(entry uint64) ([]byte, error) {
+	shift := 62 - (q.clusterBits - 8)
+	host := entry & (1<<shift - 1)
+	sectors := (entry>>shift)&(1<<(q.clusterBits-8)-1) + 1
+	compressed := make([]byte, sectors*512-host%512)
+	// the last compressed cluster may end before its last sector
+	n, err := q.r.ReadAt(compressed, int64(host))
+	if n == 0 {
+		return nil, fmt.Errorf("error reading compressed qcow2 cluster at %d: %w", host, shortReadErr(err))
+	}
+
+	cluster := make([]byte, q.clusterSize)
+	zr := flate.NewReader(bytes.NewReader(compressed[:n]))
+	defer zr.Close()
+	if _, err := stdio.ReadFull(zr, cluster); err != nil {
+		return nil, fmt.Errorf("%w: compressed qcow2 cluster at %d: %v", ErrInvalidImage, host, err)
+	}
+	return cluster, nil
+}
+
+func (q *Qcow2Image) l2Entry(pos uint64) (uint64, error) {
+	cluster := pos >> q.clusterBits
+	l1Index := cluster / q.l2Entries()
+	if l1Index >= uint64(len(q.l1)) {
+		return 0, nil
+	}
+	l2Offset := q.l1[l1Index] & qcow2OffsetMask
+	if l2Offset == 0 {
+		return 0, nil
+	}
+	table, err := q.l2Table(l2Offset)
+	if err != nil {
+		return 0, err
+	}
+	return table[cluster%q.l2Entries()], nil
+}
+
+// l2Table returns the L2 table at offset, caching the most recent ones.
+func (q *Qcow2Image) l2Table(offset uint64) ([]uint64, error) {
+	q.mu.Lock()
+	table, ok := q.l2Cache[offset]
+	q.mu.Unlock()
+	if ok {
+		return table, nil
+	}
+
+	table, err := readTable(q.r, offset, q.l2Entries())
+	if err != nil {
+		return nil, fmt.Errorf("error reading qcow2 L2 table at %d: %w", offset, err)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.l2Cache) >= l2CacheTables {
+		clear(q.l2Cache)
+	}
+	q.l2Cache[offset] = table
+	return table, nil
+}
+
+// shortReadErr returns the error for a ReadAt that came up short, which
+// io.ReaderAt allows to be nil or io.EOF.
+func shortReadErr(err error) error {
+	if err == nil || err == stdio.EOF {
+		return stdio.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readTable reads count big endian 64 bit entries at offset.
+func readTable(r stdio.ReaderAt, offset, count uint64) ([]uint64, error) {
+	buf := make([]byte, count*8)
+	if n, err := r.ReadAt(buf, int64(offset)); n < len(buf) {
+		return nil, shortReadErr(err)
+	}
+	table := make([]uint64, count)
+	for i := range table {
+		table[i] = binary.BigEndian.Uint64(buf[i*8:])
+	}
+	return table, nil
+}
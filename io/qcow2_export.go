@@ -0,0 +1,398 @@
+package io
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"os"
+	"sync"
+
+	"github.com/klauspost/compress/flate"
+)
+
+const (
+	defaultQcow2ClusterSize = 65536
+	// qcow2Copied flags L1 and L2 entries whose cluster has a refcount of
+	// exactly one
+	qcow2Copied = 1 << 63
+	// qcow2RefcountOrder selects 16 bit refcounts, the qemu default
+	qcow2RefcountOrder = 4
+	// qcow2DeflateWindow is the window qemu inflates compressed clusters
+	// with, back-references must not reach further
+	qcow2DeflateWindow = 4096
+)
+
+// Qcow2Options tunes the image written by ExportQcow2.
+type Qcow2Options struct {
+	// ClusterSize is a power of two between 512 bytes and 2 MiB, 64 KiB
+	// by default. The copy ChunkSize must be a multiple of it.
+	ClusterSize int
+	// Compress stores clusters zlib compressed when that saves space.
+	Compress bool
+}
+
+// ExportQcow2 writes src, typically a block device, to dst as a sparse
+// qcow2 v3 image that qemu can read. Only clusters holding data are stored,
+// so source holes and zero clusters take no space. The data is read by the
+// same pipeline as CopyContext, with the workers laying out clusters in dst
+// as they come. The metadata is written last, the header at the very end, so
+// an interrupted export never leaves a valid looking image behind.
+//
+// dst is truncated first and must not be open with O_DIRECT. Zero policies
+// other than ZeroSkip, Verify and checkpoints do not apply to an export and
+// are rejected.
+func ExportQcow2(ctx context.Context, src *os.File, dst *os.File, opts CopyOptions, qopts Qcow2Options) (*Result, error) {
+	if opts.ZeroPolicy != ZeroSkip || opts.Verify || opts.Checkpoint != "" {
+		return nil, fmt.Errorf("%w: qcow2 exports only support ZeroSkip, without verification or checkpoints", ErrInvalidOptions)
+	}
+	if qopts.ClusterSize == 0 {
+		qopts.ClusterSize = defaultQcow2ClusterSize
+	}
+	if qopts.ClusterSize < 512 || qopts.ClusterSize > 2*1024*1024 || qopts.ClusterSize&(qopts.ClusterSize-1) != 0 {
+		return nil, fmt.Errorf("%w: qcow2 cluster size must be a power of two between 512 and 2097152, got %d", ErrInvalidOptions, qopts.ClusterSize)
+	}
+	if blockSize, err := directBlockSize(dst); err != nil || blockSize != 0 {
+		return nil, fmt.Errorf("%w: qcow2 exports cannot be written with O_DIRECT", ErrInvalidOptions)
+	}
+
+	c, err := newFileCopier(src, dst, opts)
+	if err != nil {
+		return nil, err
+	}
+	if c.opts.ChunkSize%qopts.ClusterSize != 0 {
+		return nil, fmt.Errorf("%w: chunk size must be a multiple of the %d byte cluster size", ErrInvalidOptions, qopts.ClusterSize)
+	}
+	if err := dst.Truncate(0); err != nil {
+		return nil, err
+	}
+
+	w := newQcow2Writer(dst, c.size, qopts)
+	c.write = w.writeChunk
+	c.finish = w.finish
+	return c.run(ctx)
+}
+
+// qcow2Writer lays out the clusters of a qcow2 image as the workers hand
+// them over and writes the metadata once they are all in.
+type qcow2Writer struct {
+	dst         *os.File
+	size        uint64
+	clusterBits uint32
+	clusterSize uint64
+	compress    bool
+	compressors sync.Pool
+
+	mu sync.Mutex
+	// next is the first free host byte
+	next uint64
+	// l2 holds the L2 tables by L1 index, allocated on first use
+	l2 [][]uint64
+	// reserved holds the host bytes set aside for compressed clusters, by
+	// guest offset, for retries to write them again in place
+	reserved map[uint64]uint64
+}
+
+// qcow2Compressor is the per-worker compression state.
+type qcow2Compressor struct {
+	w       *flate.Writer
+	buf     bytes.Buffer
+	cluster []byte
+}
+
+func newQcow2Writer(dst *os.File, size uint64, qopts Qcow2Options) *qcow2Writer {
+	clusterSize := uint64(qopts.ClusterSize)
+	l2Span := clusterSize / 8 * clusterSize
+	w := &qcow2Writer{
+		dst:         dst,
+		size:        size,
+		clusterBits: uint32(bits.TrailingZeros64(clusterSize)),
+		clusterSize: clusterSize,
+		compress:    qopts.Compress,
+		// the header takes the first cluster
+		next:     clusterSize,
+		l2:       make([][]uint64, (size+l2Span-1)/l2Span),
+		reserved: map[uint64]uint64{},
+	}
+	w.compressors.New = func() any {
+		fw, _ := flate.NewWriterWindow(nil, qcow2DeflateWindow)
+		return &qcow2Compressor{w: fw, cluster: make([]byte, clusterSize)}
+	}
+	return w
+}
+
+// qcow2Run is a run of guest clusters stored next to each other on the host.
+type qcow2Run struct {
+	guest uint64
+	data  []byte
+	// compressed runs hold a single cluster
+	compressed bool
+	host       uint64
+}
+
+// writeChunk stores the non-zero clusters of the chunk at offset. A cluster
+// cut off by a short write is left out, the retry stores it whole.
+func (w *qcow2Writer) writeChunk(offset uint64, data []byte) error {
+	var comp *qcow2Compressor
+	if w.compress {
+		comp = w.compressors.Get().(*qcow2Compressor)
+		defer w.compressors.Put(comp)
+		comp.buf.Reset()
+	}
+
+	var runs []qcow2Run
+	for start := uint64(0); start < uint64(len(data)); start += w.clusterSize {
+		cluster := data[start:min(start+w.clusterSize, uint64(len(data)))]
+		if uint64(len(cluster)) < w.clusterSize && offset+start+uint64(len(cluster)) < w.size {
+			break
+		}
+		if isZeroChunk(cluster) {
+			continue
+		}
+		if comp != nil {
+			if packed, ok := comp.deflate(cluster); ok {
+				runs = append(runs, qcow2Run{guest: offset + start, data: packed, compressed: true})
+				continue
+			}
+		}
+		if n := len(runs); n > 0 && !runs[n-1].compressed && runs[n-1].guest+uint64(len(runs[n-1].data)) == offset+start {
+			runs[n-1].data = data[runs[n-1].guest-offset : start+uint64(len(cluster))]
+			continue
+		}
+		runs = append(runs, qcow2Run{guest: offset + start, data: cluster})
+	}
+	if len(runs) == 0 {
+		return nil
+	}
+
+	var pieces []qcow2Run
+	w.mu.Lock()
+	for _, run := range runs {
+		pieces = w.allocate(pieces, run)
+	}
+	w.mu.Unlock()
+
+	for _, piece := range pieces {
+		if _, err := w.dst.WriteAt(piece.data, int64(piece.host)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deflate compresses cluster, zero padded to a full cluster, into the
+// compressor's buffer. It reports false when that does not save space.
+func (c *qcow2Compressor) deflate(cluster []byte) ([]byte, bool) {
+	full := c.cluster
+	copy(full, cluster)
+	clear(full[len(cluster):])
+
+	start := c.buf.Len()
+	c.w.Reset(&c.buf)
+	if _, err := c.w.Write(full); err != nil {
+		return nil, false
+	}
+	if err := c.w.Close(); err != nil {
+		return nil, false
+	}
+	if c.buf.Len()-start >= len(full) {
+		c.buf.Truncate(start)
+		return nil, false
+	}
+	return c.buf.Bytes()[start:], true
+}
+
+// allocate reserves host space for run, maps its guest clusters and appends
+// the pieces to write to pieces. Plain clusters are cluster aligned,
+// compressed ones are packed byte by byte like qemu does. A retried chunk
+// finds its clusters mapped already and writes them again in place, so that
+// retries leak no host clusters. It is called with mu held.
+func (w *qcow2Writer) allocate(pieces []qcow2Run, run qcow2Run) []qcow2Run {
+	if run.compressed {
+		length := uint64(len(run.data))
+		host, ok := w.mapped(run.guest, length)
+		if !ok {
+			host = w.next
+			w.next += length
+			w.reserved[run.guest] = length
+		} else if _, packed := w.reserved[run.guest]; !packed {
+			// it fits in the plain cluster of an earlier attempt
+			w.reserved[run.guest] = w.clusterSize
+		}
+		shift := 62 - (w.clusterBits - 8)
+		sectors := (host+length-1)>>9 - host>>9
+		w.setEntry(run.guest, host|sectors<<shift|qcow2Compressed)
+		run.host = host
+		return append(pieces, run)
+	}
+
+	first := len(pieces)
+	for start := uint64(0); start < uint64(len(run.data)); start += w.clusterSize {
+		guest := run.guest + start
+		data := run.data[start:min(start+w.clusterSize, uint64(len(run.data)))]
+		host, ok := w.mapped(guest, w.clusterSize)
+		if !ok {
+			host = (w.next + w.clusterSize - 1) &^ (w.clusterSize - 1)
+			w.next = host + w.clusterSize
+		}
+		delete(w.reserved, guest)
+		w.setEntry(guest, host|qcow2Copied)
+		// clusters of the run that sit next to each other are written at once
+		if n := len(pieces); n > first && pieces[n-1].host+uint64(len(pieces[n-1].data)) == host {
+			pieces[n-1].data = run.data[pieces[n-1].guest-run.guest : start+uint64(len(data))]
+			continue
+		}
+		pieces = append(pieces, qcow2Run{guest: guest, data: data, host: host})
+	}
+	return pieces
+}
+
+// mapped returns the host offset guest is mapped to, if that has room for
+// length bytes.
+func (w *qcow2Writer) mapped(guest, length uint64) (uint64, bool) {
+	cluster := guest >> w.clusterBits
+	l2Entries := w.clusterSize / 8
+	table := w.l2[cluster/l2Entries]
+	if table == nil {
+		return 0, false
+	}
+	switch entry := table[cluster%l2Entries]; {
+	case entry == 0:
+		return 0, false
+	case entry&qcow2Compressed != 0:
+		shift := 62 - (w.clusterBits - 8)
+		return entry & (1<<shift - 1), w.reserved[guest] >= length
+	default:
+		return entry & qcow2OffsetMask, true
+	}
+}
+
+func (w *qcow2Writer) setEntry(guest, entry uint64) {
+	cluster := guest >> w.clusterBits
+	l2Entries := w.clusterSize / 8
+	table := w.l2[cluster/l2Entries]
+	if table == nil {
+		table = make([]uint64, l2Entries)
+		w.l2[cluster/l2Entries] = table
+	}
+	table[cluster%l2Entries] = entry
+}
+
+// finish writes the L2 tables, the L1 table and the refcounts after the
+// data, then the header.
+func (w *qcow2Writer) finish() error {
+	clusterSize := w.clusterSize
+	toClusters := func(n uint64) uint64 { return (n + clusterSize - 1) / clusterSize }
+
+	// L2 tables, then the L1 table, then the refcount blocks and table,
+	// which have to count themselves
+	cluster := toClusters(w.next)
+	l1 := make([]uint64, len(w.l2))
+	for i, table := range w.l2 {
+		if table != nil {
+			l1[i] = cluster<<w.clusterBits | qcow2Copied
+			cluster++
+		}
+	}
+	l1Offset := cluster << w.clusterBits
+	cluster += max(toClusters(uint64(len(l1))*8), 1)
+	refcountsPerBlock := clusterSize * 8 / (1 << qcow2RefcountOrder)
+	var blocks, tableClusters uint64
+	for {
+		total := cluster + blocks + tableClusters
+		b := (total + refcountsPerBlock - 1) / refcountsPerBlock
+		t := toClusters(b * 8)
+		if b == blocks && t == tableClusters {
+			break
+		}
+		blocks, tableClusters = b, t
+	}
+	blocksOffset := cluster << w.clusterBits
+	tableOffset := (cluster + blocks) << w.clusterBits
+	total := cluster + blocks + tableClusters
+
+	// every cluster but the ones holding data is referenced once
+	refcounts := make([]uint16, blocks*refcountsPerBlock)
+	refcounts[0] = 1
+	for c := toClusters(w.next); c < total; c++ {
+		refcounts[c] = 1
+	}
+	shift := 62 - (w.clusterBits - 8)
+	for _, table := range w.l2 {
+		for _, entry := range table {
+			switch {
+			case entry == 0:
+			case entry&qcow2Compressed != 0:
+				// qemu counts the sectors the compressed data spans
+				host := entry & (1<<shift - 1) &^ 511
+				sectors := (entry>>shift)&(1<<(w.clusterBits-8)-1) + 1
+				for c := host >> w.clusterBits; c <= (host+sectors*512-1)>>w.clusterBits; c++ {
+					if refcounts[c] == 0xffff {
+						return fmt.Errorf("qcow2 refcount overflow in cluster %d", c)
+					}
+					refcounts[c]++
+				}
+			default:
+				refcounts[(entry&qcow2OffsetMask)>>w.clusterBits]++
+			}
+		}
+	}
+
+	for i, table := range w.l2 {
+		if table != nil {
+			if err := w.writeEntries(table, l1[i]&qcow2OffsetMask); err != nil {
+				return err
+			}
+		}
+	}
+	if err := w.writeEntries(l1, l1Offset); err != nil {
+		return err
+	}
+	buf := make([]byte, len(refcounts)*2)
+	for i, r := range refcounts {
+		binary.BigEndian.PutUint16(buf[i*2:], r)
+	}
+	if _, err := w.dst.WriteAt(buf, int64(blocksOffset)); err != nil {
+		return err
+	}
+	table := make([]uint64, blocks)
+	for i := range table {
+		table[i] = blocksOffset + uint64(i)<<w.clusterBits
+	}
+	if err := w.writeEntries(table, tableOffset); err != nil {
+		return err
+	}
+	// the table may not fill its last cluster
+	if err := w.dst.Truncate(int64(total << w.clusterBits)); err != nil {
+		return err
+	}
+
+	var header bytes.Buffer
+	if err := binary.Write(&header, binary.BigEndian, qcow2Header{
+		Magic:                 qcow2Magic,
+		Version:               3,
+		ClusterBits:           w.clusterBits,
+		Size:                  w.size,
+		L1Size:                uint32(len(l1)),
+		L1TableOffset:         l1Offset,
+		RefcountTableOffset:   tableOffset,
+		RefcountTableClusters: uint32(tableClusters),
+		RefcountOrder:         qcow2RefcountOrder,
+		HeaderLength:          qcow2V3HeaderSize,
+	}); err != nil {
+		return err
+	}
+	_, err := w.dst.WriteAt(header.Bytes(), 0)
+	return err
+}
+
+// writeEntries writes a big endian table of 64 bit entries at offset.
+func (w *qcow2Writer) writeEntries(entries []uint64, offset uint64) error {
+	buf := make([]byte, len(entries)*8)
+	for i, e := range entries {
+		binary.BigEndian.PutUint64(buf[i*8:], e)
+	}
+	_, err := w.dst.WriteAt(buf, int64(offset))
+	return err
+}
@@ -0,0 +1,234 @@
+package io
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	stdio "io"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// createExportSource writes an image with random data, compressible data,
+// allocated zeros, a hole and a partial last cluster.
+func (suite *IOTestSuite) createExportSource() (*os.File, []byte) {
+	data := make([]byte, 8*1024*1024+3000)
+	_, err := rand.Read(data[1024*1024 : 2*1024*1024])
+	suite.Require().NoError(err)
+	copy(data[4*1024*1024:5*1024*1024], bytes.Repeat([]byte("harvester qcow2 export "), 1024*1024/23))
+	_, err = rand.Read(data[len(data)-3000:])
+	suite.Require().NoError(err)
+
+	srcFile, err := os.CreateTemp("", "export_source")
+	suite.Require().NoError(err)
+	suite.Require().NoError(srcFile.Truncate(int64(len(data))))
+	for _, r := range [][2]int{{1024 * 1024, 2 * 1024 * 1024}, {4 * 1024 * 1024, 7 * 1024 * 1024}, {len(data) - 3000, len(data)}} {
+		_, err = srcFile.WriteAt(data[r[0]:r[1]], int64(r[0]))
+		suite.Require().NoError(err)
+	}
+	return srcFile, data
+}
+
+func (suite *IOTestSuite) TestExportQcow2() {
+	srcFile, data := suite.createExportSource()
+	defer os.Remove(srcFile.Name())
+
+	sizes := map[bool]int64{}
+	for _, qopts := range []Qcow2Options{
+		{},
+		{Compress: true},
+		{ClusterSize: 4096, Compress: true},
+	} {
+		dstFile, err := os.CreateTemp("", "export.qcow2")
+		suite.Require().NoError(err)
+		defer os.Remove(dstFile.Name())
+
+		_, err = ExportQcow2(context.Background(), srcFile, dstFile, CopyOptions{ChunkSize: 256 * 1024}, qopts)
+		suite.Require().NoError(err)
+
+		image, err := os.ReadFile(dstFile.Name())
+		suite.Require().NoError(err)
+		assert.Equal(suite.T(), data, suite.parseQcow2Export(image))
+		if qopts.ClusterSize == 0 {
+			sizes[qopts.Compress] = int64(len(image))
+		}
+
+		img, err := NewQcow2Image(bytes.NewReader(image))
+		suite.Require().NoError(err)
+		got := make([]byte, img.Size())
+		_, err = img.ReadAt(got, 0)
+		suite.Require().NoError(err)
+		assert.Equal(suite.T(), data, got)
+
+		if qemuImg, err := exec.LookPath("qemu-img"); err == nil {
+			out, err := exec.Command(qemuImg, "check", dstFile.Name()).CombinedOutput()
+			assert.NoError(suite.T(), err, string(out))
+		}
+	}
+
+	// 2M of data plus metadata, the compressible megabyte shrinks
+	assert.Less(suite.T(), sizes[false], int64(3*1024*1024))
+	assert.Less(suite.T(), sizes[true], sizes[false]-512*1024)
+}
+
+func (suite *IOTestSuite) TestExportQcow2Retry() {
+	srcFile, data := suite.createExportSource()
+	defer os.Remove(srcFile.Name())
+
+	// half written clusters, plain and compressed, go through again
+	faults := NewFaultPlan(
+		Fault{Op: FaultWrite, Offset: 1024 * 1024, AtOffset: true, Short: 100*1024 + 3000, Err: syscall.EIO},
+		Fault{Op: FaultWrite, Offset: 4 * 1024 * 1024, AtOffset: true, Short: 100*1024 + 3000, Err: syscall.EIO},
+		Fault{Op: FaultWrite, Offset: uint64(len(data)) - 1000, AtOffset: true, Err: syscall.EIO},
+	)
+	dstFile, err := os.CreateTemp("", "export.qcow2")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	opts := CopyOptions{
+		ChunkSize: 256 * 1024,
+		Faults:    faults,
+		Retry:     RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+	}
+	res, err := ExportQcow2(context.Background(), srcFile, dstFile, opts, Qcow2Options{Compress: true})
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), uint64(3), res.Retries)
+
+	// the retries wrote their clusters in place, none of them is leaked
+	image, err := os.ReadFile(dstFile.Name())
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), data, suite.parseQcow2Export(image))
+}
+
+func (suite *IOTestSuite) TestExportQcow2Invalid() {
+	srcFile, _ := suite.createRandomFile(64 * 1024)
+	defer os.Remove(srcFile.Name())
+	dstFile, err := os.CreateTemp("", "export.qcow2")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	ctx := context.Background()
+	_, err = ExportQcow2(ctx, srcFile, dstFile, CopyOptions{Verify: true}, Qcow2Options{})
+	assert.ErrorIs(suite.T(), err, ErrInvalidOptions)
+	_, err = ExportQcow2(ctx, srcFile, dstFile, CopyOptions{}, Qcow2Options{ClusterSize: 3000})
+	assert.ErrorIs(suite.T(), err, ErrInvalidOptions)
+	_, err = ExportQcow2(ctx, srcFile, dstFile, CopyOptions{ChunkSize: 64 * 1024}, Qcow2Options{ClusterSize: 128 * 1024})
+	assert.ErrorIs(suite.T(), err, ErrInvalidOptions)
+}
+
+// parseQcow2Export walks image by the qcow2 spec alone, without the reader or
+// any of the layout constants of this package, and returns its guest data.
+// It recounts the references to every host cluster the way qemu-img check
+// does, compares them to the stored refcounts and to the COPIED flags, and
+// fails on clusters nothing references.
+func (suite *IOTestSuite) parseQcow2Export(image []byte) []byte {
+	be := binary.BigEndian
+	suite.Require().Greater(len(image), 104)
+	suite.Require().Equal([]byte("QFI\xfb"), image[:4], "magic")
+	suite.Require().Equal(uint32(3), be.Uint32(image[4:]), "version")
+	suite.Require().Zero(be.Uint64(image[8:]), "backing file offset")
+	clusterBits := be.Uint32(image[20:])
+	size := be.Uint64(image[24:])
+	suite.Require().Zero(be.Uint32(image[32:]), "crypt method")
+	l1Size := uint64(be.Uint32(image[36:]))
+	l1Offset := be.Uint64(image[40:])
+	refcountTableOffset := be.Uint64(image[48:])
+	refcountTableClusters := uint64(be.Uint32(image[56:]))
+	suite.Require().Zero(be.Uint32(image[60:]), "snapshots")
+	suite.Require().Zero(be.Uint64(image[72:]), "incompatible features")
+	suite.Require().Equal(uint32(4), be.Uint32(image[96:]), "refcount order")
+	suite.Require().Equal(uint32(104), be.Uint32(image[100:]), "header length")
+
+	clusterSize := uint64(1) << clusterBits
+	suite.Require().Zero(uint64(len(image))%clusterSize, "image size")
+	for _, offset := range []uint64{l1Offset, refcountTableOffset} {
+		suite.Require().Zero(offset%clusterSize, "table alignment")
+	}
+	l2Entries := clusterSize / 8
+	suite.Require().Equal((size+l2Entries*clusterSize-1)/(l2Entries*clusterSize), l1Size, "L1 size")
+
+	clusters := uint64(len(image)) / clusterSize
+	expected := make([]int, clusters)
+	ref := func(offset, length uint64) {
+		for c := offset / clusterSize; c <= (offset+length-1)/clusterSize; c++ {
+			suite.Require().Less(c, clusters, "reference past the end of the image")
+			expected[c]++
+		}
+	}
+	ref(0, clusterSize)
+	ref(l1Offset, l1Size*8)
+	ref(refcountTableOffset, refcountTableClusters*clusterSize)
+	var blocks []uint64
+	for i := uint64(0); i < refcountTableClusters*clusterSize/8; i++ {
+		if block := be.Uint64(image[refcountTableOffset+i*8:]); block != 0 {
+			suite.Require().Zero(block%clusterSize, "refcount block alignment")
+			blocks = append(blocks, block)
+			ref(block, clusterSize)
+		}
+	}
+
+	const (
+		copied     = uint64(1) << 63
+		compressed = uint64(1) << 62
+		offsetMask = uint64(0x00fffffffffffe00)
+	)
+	guest := make([]byte, size)
+	x := 62 - (clusterBits - 8)
+	var standard []uint64
+	for i := uint64(0); i < l1Size; i++ {
+		l1 := be.Uint64(image[l1Offset+i*8:])
+		if l1 == 0 {
+			continue
+		}
+		suite.Require().NotZero(l1&copied, "L1 entry without COPIED")
+		l2Offset := l1 & offsetMask
+		suite.Require().Zero(l2Offset%clusterSize, "L2 table alignment")
+		ref(l2Offset, clusterSize)
+		for j := uint64(0); j < l2Entries; j++ {
+			e := be.Uint64(image[l2Offset+j*8:])
+			offset := (i*l2Entries + j) * clusterSize
+			if e == 0 {
+				continue
+			}
+			suite.Require().Less(offset, size, "mapping past the guest size")
+			cluster := guest[offset:min(offset+clusterSize, size)]
+			if e&compressed != 0 {
+				suite.Require().Zero(e&copied, "compressed entry with COPIED")
+				host := e & (1<<x - 1)
+				end := host&^511 + ((e>>x)&(1<<(clusterBits-8)-1)+1)*512
+				ref(host&^511, end-host&^511)
+				data, err := stdio.ReadAll(flate.NewReader(bytes.NewReader(image[host:min(end, uint64(len(image)))])))
+				suite.Require().NoError(err, "compressed cluster at %d", host)
+				suite.Require().Len(data, int(clusterSize))
+				copy(cluster, data)
+				continue
+			}
+			suite.Require().Zero(e&^(copied|offsetMask), "L2 entry flags")
+			host := e & offsetMask
+			suite.Require().Zero(host%clusterSize, "cluster alignment")
+			ref(host, clusterSize)
+			copy(cluster, image[host:])
+			standard = append(standard, e)
+		}
+	}
+
+	refcountsPerBlock := clusterSize / 2
+	for c := uint64(0); c < clusters; c++ {
+		var stored uint16
+		if b := c / refcountsPerBlock; b < uint64(len(blocks)) {
+			stored = be.Uint16(image[blocks[b]+c%refcountsPerBlock*2:])
+		}
+		suite.Require().Equal(expected[c], int(stored), "refcount of cluster %d", c)
+		suite.Require().NotZero(expected[c], "cluster %d is leaked", c)
+	}
+	for _, e := range standard {
+		suite.Require().Equal(expected[(e&offsetMask)/clusterSize] == 1, e&copied != 0, "COPIED flag")
+	}
+	return guest
+}
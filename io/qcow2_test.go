@@ -0,0 +1,249 @@
+package io
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	stdio "io"
+	"os"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type qcow2Fixture struct {
+	version     uint32
+	clusterBits uint32
+	size        uint64
+	backingFile bool
+	incompat    uint64
+}
+
+// build lays out a qcow2 image by hand: header, L1 table, L2 tables and then
+// the clusters. Clusters cycle through data, compressed, zero (data on v2)
+// and unallocated ones, so that every kind shows up in every L2 table. It
+// returns the image and its guest view.
+func (f qcow2Fixture) build(suite *IOTestSuite) ([]byte, []byte) {
+	clusterSize := uint64(1) << f.clusterBits
+	l2Entries := clusterSize / 8
+	clusters := (f.size + clusterSize - 1) / clusterSize
+	l1Size := (clusters + l2Entries - 1) / l2Entries
+
+	guest := make([]byte, f.size)
+	image := make([]byte, 2*clusterSize) // header and L1 table
+	// alloc appends length bytes, cluster aligned unless packed is set
+	alloc := func(length uint64, packed bool) uint64 {
+		offset := uint64(len(image))
+		if !packed {
+			offset = (offset + clusterSize - 1) / clusterSize * clusterSize
+		}
+		image = append(image, make([]byte, offset+length-uint64(len(image)))...)
+		return offset
+	}
+	l2Offsets := make([]uint64, l1Size)
+	for i := range l2Offsets {
+		l2Offsets[i] = alloc(clusterSize, false)
+		binary.BigEndian.PutUint64(image[clusterSize+uint64(i)*8:], l2Offsets[i]|1<<63)
+	}
+
+	for i := uint64(0); i < clusters; i++ {
+		start := i * clusterSize
+		length := min(clusterSize, f.size-start)
+		data := make([]byte, clusterSize)
+		_, err := rand.Read(data[:length])
+		suite.Require().NoError(err)
+
+		var entry uint64
+		kind := i % 5
+		if i == clusters-1 {
+			kind = 0
+		}
+		switch {
+		case kind == 0 || kind == 3 || (kind == 2 && f.version == 2):
+			entry = alloc(clusterSize, false)
+			copy(image[entry:], data)
+			copy(guest[start:], data[:length])
+		case kind == 1:
+			var buf bytes.Buffer
+			w, err := flate.NewWriter(&buf, flate.BestSpeed)
+			suite.Require().NoError(err)
+			_, err = w.Write(data)
+			suite.Require().NoError(err)
+			suite.Require().NoError(w.Close())
+			// compressed clusters are byte aligned, start off a sector
+			host := alloc(uint64(buf.Len())+7, true) + 7
+			copy(image[host:], buf.Bytes())
+			shift := 62 - (f.clusterBits - 8)
+			sectors := (host+uint64(buf.Len())-1)>>9 - host>>9
+			entry = host | sectors<<shift | qcow2Compressed
+			copy(guest[start:], data[:length])
+		case kind == 2:
+			// a preallocated zero cluster whose stale data must not show
+			entry = alloc(clusterSize, false)
+			copy(image[entry:], data)
+			entry |= qcow2ZeroFlag
+		}
+		binary.BigEndian.PutUint64(image[l2Offsets[i/l2Entries]+i%l2Entries*8:], entry)
+	}
+
+	h := qcow2Header{
+		Magic:         qcow2Magic,
+		Version:       f.version,
+		ClusterBits:   f.clusterBits,
+		Size:          f.size,
+		L1Size:        uint32(l1Size),
+		L1TableOffset: clusterSize,
+		RefcountOrder: 4,
+	}
+	if f.version == 3 {
+		h.HeaderLength = qcow2V3HeaderSize
+		h.IncompatibleFeatures = f.incompat
+	}
+	if f.backingFile {
+		h.BackingFileOffset = qcow2V3HeaderSize
+		h.BackingFileSize = 4
+	}
+	var header bytes.Buffer
+	suite.Require().NoError(binary.Write(&header, binary.BigEndian, h))
+	copy(image, header.Bytes())
+	return image, guest
+}
+
+func (suite *IOTestSuite) writeTemp(data []byte) *os.File {
+	f, err := os.CreateTemp("", "image")
+	suite.Require().NoError(err)
+	_, err = f.Write(data)
+	suite.Require().NoError(err)
+	return f
+}
+
+func (suite *IOTestSuite) TestQcow2Read() {
+	for _, fixture := range []qcow2Fixture{
+		{version: 2, clusterBits: 16, size: 2*1024*1024 + 1536},
+		{version: 3, clusterBits: 16, size: 2*1024*1024 + 1536},
+		// 512 byte clusters spread the image over several L2 tables
+		{version: 3, clusterBits: 9, size: 300 * 1024, incompat: qcow2IncompatDirty},
+	} {
+		image, guest := fixture.build(suite)
+		imageFile := suite.writeTemp(image)
+		defer os.Remove(imageFile.Name())
+
+		img, err := OpenQcow2(imageFile.Name())
+		suite.Require().NoError(err)
+		defer img.Close()
+		assert.Equal(suite.T(), fixture.size, img.Size())
+
+		got := make([]byte, len(guest))
+		n, err := img.ReadAt(got, 0)
+		suite.Require().NoError(err)
+		assert.Equal(suite.T(), len(guest), n)
+		assert.Equal(suite.T(), guest, got)
+
+		// reads across cluster boundaries and past the end
+		n, err = img.ReadAt(got[:1000], int64(fixture.size)-600)
+		assert.Equal(suite.T(), 600, n)
+		assert.Error(suite.T(), err)
+		assert.Equal(suite.T(), guest[fixture.size-600:], got[:600])
+
+		extents, err := img.Extents()
+		suite.Require().NoError(err)
+		var allocated uint64
+		for _, e := range extents {
+			allocated += e.Length
+		}
+		assert.Less(suite.T(), allocated, fixture.size)
+		assert.Greater(suite.T(), allocated, fixture.size/3)
+
+		dstFile, err := os.CreateTemp("", "dstfile")
+		suite.Require().NoError(err)
+		defer os.Remove(dstFile.Name())
+		var last Progress
+		_, err = CopyFrom(context.Background(), img, dstFile, CopyOptions{
+			ChunkSize:  64 * 1024,
+			ZeroPolicy: ZeroPunchHole,
+			Verify:     true,
+			Progress:   func(p Progress) { last = p },
+		})
+		suite.Require().NoError(err)
+		assert.Equal(suite.T(), fixture.size, last.BytesRead+last.HoleBytes)
+
+		dstData, err := os.ReadFile(dstFile.Name())
+		suite.Require().NoError(err)
+		assert.Equal(suite.T(), guest, dstData)
+	}
+}
+
+func (suite *IOTestSuite) TestQcow2Unsupported() {
+	for name, fixture := range map[string]qcow2Fixture{
+		"backing file": {version: 3, clusterBits: 16, size: 1024 * 1024, backingFile: true},
+		"extended L2":  {version: 3, clusterBits: 16, size: 1024 * 1024, incompat: 1 << 4},
+		"version":      {version: 4, clusterBits: 16, size: 1024 * 1024},
+	} {
+		image, _ := fixture.build(suite)
+		_, err := NewQcow2Image(bytes.NewReader(image))
+		assert.ErrorIs(suite.T(), err, ErrUnsupportedImage, name)
+	}
+
+	_, err := NewQcow2Image(bytes.NewReader(make([]byte, 4096)))
+	assert.ErrorIs(suite.T(), err, ErrInvalidImage)
+}
+
+// shortReaderAt comes up short past limit without an error, as io.ReaderAt
+// allows.
+type shortReaderAt struct {
+	data  []byte
+	limit int64
+}
+
+func (r shortReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.limit {
+		return 0, nil
+	}
+	return copy(p[:min(int64(len(p)), r.limit-off)], r.data[off:]), nil
+}
+
+func (suite *IOTestSuite) TestQcow2ShortRead() {
+	fixture := qcow2Fixture{version: 3, clusterBits: 16, size: 1024 * 1024}
+	image, _ := fixture.build(suite)
+
+	// the header and the L1 table are there, the L2 tables are cut off
+	img, err := NewQcow2Image(shortReaderAt{data: image, limit: 2 << fixture.clusterBits})
+	suite.Require().NoError(err)
+	_, err = img.ReadAt(make([]byte, 4096), 0)
+	assert.ErrorIs(suite.T(), err, stdio.ErrUnexpectedEOF)
+	_, err = img.Extents()
+	assert.ErrorIs(suite.T(), err, stdio.ErrUnexpectedEOF)
+
+	// the data clusters are cut off
+	img, err = NewQcow2Image(shortReaderAt{data: image, limit: 3 << fixture.clusterBits})
+	suite.Require().NoError(err)
+	_, err = img.ReadAt(make([]byte, 4096), 0)
+	assert.ErrorIs(suite.T(), err, stdio.ErrUnexpectedEOF)
+
+	_, err = NewQcow2Image(shortReaderAt{data: image, limit: 16})
+	assert.ErrorIs(suite.T(), err, ErrInvalidImage)
+}
+
+// shortSource is a Source whose data all counts as allocated.
+type shortSource struct {
+	shortReaderAt
+}
+
+func (s shortSource) Size() uint64 {
+	return uint64(len(s.data))
+}
+
+func (s shortSource) Extents() ([]Extent, error) {
+	return []Extent{{Length: s.Size()}}, nil
+}
+
+func (suite *IOTestSuite) TestCopyFromShortRead() {
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	src := shortSource{shortReaderAt{data: make([]byte, 256*1024), limit: 100 * 1024}}
+	_, err = CopyFrom(context.Background(), src, dstFile, CopyOptions{ChunkSize: 64 * 1024})
+	assert.ErrorIs(suite.T(), err, stdio.ErrUnexpectedEOF)
+}
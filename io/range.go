@@ -0,0 +1,36 @@
+package io
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// CopyRange copies length bytes of src starting at srcOff to dst starting at
+// dstOff, for instance to extract a partition from a disk image or to write
+// a payload inside a larger device. It runs the same pipeline as
+// CopyContext. Both offsets must be multiples of opts.Alignment, and when
+// either file is open with O_DIRECT the alignment must also be a multiple
+// of its logical block size. Only the length may be unaligned. A
+// *CancelError carries the offset committed within the range.
+//
+// The range must lie within src. Checkpoints are not supported, since a
+// journal covers a whole image.
+func CopyRange(ctx context.Context, src *os.File, srcOff uint64, dst *os.File, dstOff uint64, length uint64, opts CopyOptions) (*Result, error) {
+	if opts.Checkpoint != "" {
+		return nil, fmt.Errorf("%w: checkpoints are not supported for ranged copies", ErrInvalidOptions)
+	}
+	srcSize, err := getSourceVolSize(src)
+	if err != nil {
+		return nil, fmt.Errorf("error getting file size: %w", err)
+	}
+	if srcOff > srcSize || length > srcSize-srcOff {
+		return nil, fmt.Errorf("%w: range [%d, %d) is past the %d byte source", ErrInvalidOptions, srcOff, srcOff+length, srcSize)
+	}
+
+	c, err := newRangeCopier(src, srcOff, dst, dstOff, length, opts)
+	if err != nil {
+		return nil, err
+	}
+	return c.run(ctx)
+}
@@ -0,0 +1,63 @@
+package io
+
+import (
+	"context"
+	"os"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *IOTestSuite) TestCopyRange() {
+	srcFile, data := suite.createRandomFile(1024 * 1024) // 1M
+	defer os.Remove(srcFile.Name())
+	dstFile, dstData := suite.createRandomFile(1024 * 1024)
+	defer os.Remove(dstFile.Name())
+
+	const srcOff, dstOff, length = 64 * 1024, 512 * 1024, 300*1024 + 5
+	_, err := CopyRange(context.Background(), srcFile, srcOff, dstFile, dstOff, length, CopyOptions{ChunkSize: 64 * 1024, Verify: true})
+	suite.Require().NoError(err)
+
+	copy(dstData[dstOff:], data[srcOff:srcOff+length])
+	got, err := os.ReadFile(dstFile.Name())
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), dstData, got, "only the range should be overwritten")
+}
+
+func (suite *IOTestSuite) TestCopyRangeSparse() {
+	srcFile, data := suite.createSparseFile()
+	defer os.Remove(srcFile.Name())
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	// the range starts off the chunk grid and covers both data ranges
+	const srcOff, length = 8*1024*1024 - 4096, 40 * 1024 * 1024
+	res, err := CopyRange(context.Background(), srcFile, srcOff, dstFile, 4096, length,
+		CopyOptions{ChunkSize: 1024 * 1024, ZeroPolicy: ZeroWrite, Verify: true})
+	suite.Require().NoError(err)
+	assert.Empty(suite.T(), res.Mismatches)
+
+	got, err := os.ReadFile(dstFile.Name())
+	suite.Require().NoError(err)
+	suite.Require().Len(got, 4096+length)
+	assert.Equal(suite.T(), make([]byte, 4096), got[:4096])
+	assert.Equal(suite.T(), data[srcOff:srcOff+length], got[4096:])
+}
+
+func (suite *IOTestSuite) TestCopyRangeInvalid() {
+	srcFile, _ := suite.createRandomFile(64 * 1024)
+	defer os.Remove(srcFile.Name())
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	ctx := context.Background()
+	_, err = CopyRange(ctx, srcFile, 100, dstFile, 0, 4096, CopyOptions{})
+	assert.ErrorIs(suite.T(), err, ErrInvalidOptions, "unaligned source offset")
+	_, err = CopyRange(ctx, srcFile, 0, dstFile, 4096+512, 4096, CopyOptions{})
+	assert.ErrorIs(suite.T(), err, ErrInvalidOptions, "unaligned destination offset")
+	_, err = CopyRange(ctx, srcFile, 32*1024, dstFile, 0, 64*1024, CopyOptions{})
+	assert.ErrorIs(suite.T(), err, ErrInvalidOptions, "range past the source")
+	_, err = CopyRange(ctx, srcFile, 0, dstFile, 0, 4096, CopyOptions{Checkpoint: dstFile.Name() + ".journal"})
+	assert.ErrorIs(suite.T(), err, ErrInvalidOptions, "checkpoint")
+}
@@ -0,0 +1,94 @@
+package io
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket limiting the bytes per second written by a
+// copy. A single limiter is shared by all workers of a copy, and may be shared
+// by several copies to cap them together. The limit can be changed at any
+// time with SetLimit, which also wakes up the workers waiting on the old one.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	// changed is closed and replaced whenever the limit changes
+	changed chan struct{}
+}
+
+// NewRateLimiter returns a limiter allowing bytesPerSec bytes per second with
+// bursts of up to burst bytes. A zero or negative bytesPerSec means no limit,
+// and a zero or negative burst defaults to one second worth of bytes.
+func NewRateLimiter(bytesPerSec, burst int64) *RateLimiter {
+	l := &RateLimiter{changed: make(chan struct{})}
+	l.SetLimit(bytesPerSec, burst)
+	l.tokens = l.burst
+	return l
+}
+
+// SetLimit changes the limit of a limiter that may be in use.
+func (l *RateLimiter) SetLimit(bytesPerSec, burst int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	l.rate = math.Max(float64(bytesPerSec), 0)
+	l.burst = float64(burst)
+	if burst <= 0 {
+		l.burst = l.rate
+	}
+	l.tokens = math.Min(l.tokens, l.burst)
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// Limit returns the current limit.
+func (l *RateLimiter) Limit() (bytesPerSec, burst int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int64(l.rate), int64(l.burst)
+}
+
+// WaitN blocks until n bytes may be transferred or ctx is done. Requests
+// larger than the burst are let through once the bucket is full, leaving it
+// in debt, so a chunk size above the burst only smooths the rate.
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	for {
+		l.mu.Lock()
+		if l.rate == 0 {
+			l.mu.Unlock()
+			return nil
+		}
+		l.refill(time.Now())
+		need := math.Min(float64(n), l.burst)
+		if l.tokens >= need {
+			l.tokens -= float64(n)
+			l.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((need - l.tokens) / l.rate * float64(time.Second))
+		changed := l.changed
+		l.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-changed:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+func (l *RateLimiter) refill(now time.Time) {
+	if !l.last.IsZero() {
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+}
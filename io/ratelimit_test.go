@@ -0,0 +1,70 @@
+package io
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *IOTestSuite) TestCopyRateLimit() {
+	srcFile, data := suite.createRandomFile(2 * 1024 * 1024) // 2M
+	defer os.Remove(srcFile.Name())
+
+	// Create a temporary dstFile for testing
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	// 4M/s with a 64K burst needs close to half a second for 2M
+	limiter := NewRateLimiter(4*1024*1024, 64*1024)
+	start := time.Now()
+	_, err = CopyContext(context.Background(), srcFile, dstFile, CopyOptions{ChunkSize: 64 * 1024, RateLimiter: limiter})
+	suite.Require().NoError(err)
+	assert.GreaterOrEqual(suite.T(), time.Since(start), 400*time.Millisecond)
+
+	dstData := make([]byte, len(data))
+	_, err = dstFile.ReadAt(dstData, 0)
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), data, dstData)
+}
+
+func (suite *IOTestSuite) TestCopyRateLimitChanged() {
+	srcFile, _ := suite.createRandomFile(2 * 1024 * 1024) // 2M
+	defer os.Remove(srcFile.Name())
+
+	// Create a temporary dstFile for testing
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	// At 128K/s the copy would take 16s, lift the limit shortly after start
+	limiter := NewRateLimiter(128*1024, 64*1024)
+	time.AfterFunc(100*time.Millisecond, func() { limiter.SetLimit(0, 0) })
+	start := time.Now()
+	_, err = CopyContext(context.Background(), srcFile, dstFile, CopyOptions{ChunkSize: 64 * 1024, RateLimiter: limiter})
+	suite.Require().NoError(err)
+	assert.Less(suite.T(), time.Since(start), 5*time.Second)
+	rate, burst := limiter.Limit()
+	assert.Zero(suite.T(), rate)
+	assert.Zero(suite.T(), burst)
+}
+
+func (suite *IOTestSuite) TestCopyRateLimitCancelled() {
+	srcFile, _ := suite.createRandomFile(2 * 1024 * 1024) // 2M
+	defer os.Remove(srcFile.Name())
+
+	// Create a temporary dstFile for testing
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	limiter := NewRateLimiter(64*1024, 64*1024)
+	_, err = CopyContext(ctx, srcFile, dstFile, CopyOptions{ChunkSize: 64 * 1024, RateLimiter: limiter})
+	var cancelErr *CancelError
+	suite.Require().ErrorAs(err, &cancelErr)
+	assert.ErrorIs(suite.T(), err, context.DeadlineExceeded)
+}
@@ -0,0 +1,153 @@
+//go:build cgo && !purego
+
+package io
+
+// The C helpers below back PWrite and PReadExact when cgo is available, see
+// rawio_purego.go for the pure-Go build.
+
+/*
+#include <fcntl.h>
+#include <stdlib.h>
+#include <unistd.h>
+#include <string.h>
+#include <errno.h>
+
+// Safe_pwrite is a wrapper around pwrite(2) that retries on EINTR.
+ssize_t safe_pwrite(int fd, const void *buf, size_t count, off_t offset)
+{
+        while (count > 0) {
+                ssize_t r = pwrite(fd, buf, count, offset);
+                if (r < 0) {
+                        if (errno == EINTR)
+                                continue;
+                        return -errno;
+                }
+                count -= r;
+                buf = (char *)buf + r;
+                offset += r;
+        }
+        return 0;
+}
+ssize_t safe_pread(int fd, void *buf, size_t count, off_t offset)
+{
+        size_t cnt = 0;
+        char *b = (char*)buf;
+
+        while (cnt < count) {
+                ssize_t r = pread(fd, b + cnt, count - cnt, offset + cnt);
+                if (r <= 0) {
+                        if (r == 0) {
+                                // EOF
+                                return cnt;
+                        }
+                        if (errno == EINTR)
+                                continue;
+                        return -errno;
+                }
+                cnt += r;
+        }
+        return cnt;
+}
+ssize_t safe_pread_exact(int fd, void *buf, size_t count, off_t offset)
+{
+        ssize_t ret = safe_pread(fd, buf, count, offset);
+        if (ret < 0)
+                return ret;
+        if ((size_t)ret != count)
+                return -EDOM;
+        return 0;
+}
+// Write data to a file descriptor with O_DIRECT
+int directWrite(int fd, void *buf, size_t count, off_t offset) {
+    return safe_pwrite(fd, buf, count, offset);
+}
+// Read data from a file descriptor with O_DIRECT
+int directRead(int fd, void *buf, size_t count, off_t offset) {
+	return safe_pread(fd, buf, count, offset);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+func PWrite(dst *os.File, data []byte, size int, offset uint64) (int, error) {
+	if _, ok := unalignedDirect(dst, size); ok {
+		if err := pwriteDirectTail(dst, data[:size], offset); err != nil {
+			return 0, newIOError(OpWrite, dst, offset, uint64(size), err)
+		}
+		return 0, nil
+	}
+	writeBuffer := unsafe.Pointer(&data[0])
+	if !isAligned(data) {
+		// O_DIRECT needs an aligned buffer, bounce through C memory
+		alignedBuffer, err := allocAligned(size)
+		if err != nil {
+			return 0, newIOError(OpWrite, dst, offset, uint64(size), err)
+		}
+		defer freeAligned(alignedBuffer)
+
+		// Copy the Go data into the C buffer
+		copy(alignedBuffer, data[:size])
+		writeBuffer = unsafe.Pointer(&alignedBuffer[0])
+	}
+
+	// Call the C function to write with O_DIRECT
+	ret := C.directWrite(C.int(dst.Fd()), writeBuffer, C.size_t(size), C.off_t(offset))
+	if ret < 0 {
+		return 0, newIOError(OpWrite, dst, offset, uint64(size), syscall.Errno(-ret))
+	}
+
+	return int(ret), nil
+}
+
+func PReadExact(src *os.File, buf []byte, count int, offset uint64) (int, error) {
+	if blockSize, ok := unalignedDirect(src, count); ok {
+		n, err := preadDirectTail(src, buf, count, offset, blockSize)
+		if err != nil {
+			return 0, newIOError(OpRead, src, offset, uint64(count), err)
+		}
+		return n, nil
+	}
+	readBuffer := unsafe.Pointer(&buf[0])
+	if !isAligned(buf) {
+		// O_DIRECT needs an aligned buffer, bounce through C memory
+		alignedBuffer, err := allocAligned(count)
+		if err != nil {
+			return 0, newIOError(OpRead, src, offset, uint64(count), err)
+		}
+		defer freeAligned(alignedBuffer)
+		readBuffer = unsafe.Pointer(&alignedBuffer[0])
+	}
+
+	// Call the C function to read with O_DIRECT
+	ret := C.directRead(C.int(src.Fd()), readBuffer, C.size_t(count), C.off_t(offset))
+	if ret < 0 {
+		return 0, newIOError(OpRead, src, offset, uint64(count), syscall.Errno(-ret))
+	}
+
+	if readBuffer != unsafe.Pointer(&buf[0]) {
+		// Copy the C data into the Go buffer
+		C.memcpy(unsafe.Pointer(&buf[0]), readBuffer, C.size_t(ret))
+	}
+
+	return int(ret), nil
+}
+
+// allocAligned returns size bytes of page-aligned C memory, which must be
+// released with freeAligned.
+func allocAligned(size int) ([]byte, error) {
+	var ptr unsafe.Pointer
+	if rc := C.posix_memalign((*unsafe.Pointer)(unsafe.Pointer(&ptr)), C.size_t(baseAlignSize), C.size_t(size)); rc != 0 {
+		return nil, fmt.Errorf("error allocating aligned memory: %w", syscall.Errno(rc))
+	}
+	return unsafe.Slice((*byte)(ptr), size), nil
+}
+
+func freeAligned(buf []byte) {
+	C.free(unsafe.Pointer(unsafe.SliceData(buf)))
+}
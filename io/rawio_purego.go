@@ -0,0 +1,123 @@
+//go:build !cgo || purego
+
+package io
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// This file is the pure-Go counterpart of rawio_cgo.go, used when cgo is
+// disabled or the purego build tag is set. Aligned memory comes from
+// anonymous mmaps and pread/pwrite are retried on EINTR like the C helpers.
+
+func PWrite(dst *os.File, data []byte, size int, offset uint64) (int, error) {
+	if _, ok := unalignedDirect(dst, size); ok {
+		if err := pwriteDirectTail(dst, data[:size], offset); err != nil {
+			return 0, newIOError(OpWrite, dst, offset, uint64(size), err)
+		}
+		return 0, nil
+	}
+	writeBuffer := data[:size]
+	if !isAligned(writeBuffer) {
+		// O_DIRECT needs an aligned buffer, bounce through mmap'd memory
+		alignedBuffer, err := allocAligned(size)
+		if err != nil {
+			return 0, newIOError(OpWrite, dst, offset, uint64(size), err)
+		}
+		defer freeAligned(alignedBuffer)
+		copy(alignedBuffer, writeBuffer)
+		writeBuffer = alignedBuffer
+	}
+
+	if err := safePWrite(int(dst.Fd()), writeBuffer, int64(offset)); err != nil {
+		return 0, newIOError(OpWrite, dst, offset, uint64(size), err)
+	}
+
+	return 0, nil
+}
+
+func PReadExact(src *os.File, buf []byte, count int, offset uint64) (int, error) {
+	if blockSize, ok := unalignedDirect(src, count); ok {
+		n, err := preadDirectTail(src, buf, count, offset, blockSize)
+		if err != nil {
+			return 0, newIOError(OpRead, src, offset, uint64(count), err)
+		}
+		return n, nil
+	}
+	readBuffer := buf[:count]
+	if !isAligned(readBuffer) {
+		// O_DIRECT needs an aligned buffer, bounce through mmap'd memory
+		alignedBuffer, err := allocAligned(count)
+		if err != nil {
+			return 0, newIOError(OpRead, src, offset, uint64(count), err)
+		}
+		defer freeAligned(alignedBuffer)
+		readBuffer = alignedBuffer
+	}
+
+	ret, err := safePRead(int(src.Fd()), readBuffer, int64(offset))
+	if err != nil {
+		return 0, newIOError(OpRead, src, offset, uint64(count), err)
+	}
+
+	if &readBuffer[0] != &buf[0] {
+		copy(buf, readBuffer[:ret])
+	}
+
+	return ret, nil
+}
+
+// safePWrite writes all of buf at offset, retrying on EINTR.
+func safePWrite(fd int, buf []byte, offset int64) error {
+	for len(buf) > 0 {
+		r, err := unix.Pwrite(fd, buf, offset)
+		if errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		buf = buf[r:]
+		offset += int64(r)
+	}
+	return nil
+}
+
+// safePRead reads up to len(buf) bytes at offset, retrying on EINTR. It
+// returns fewer bytes only at end of file.
+func safePRead(fd int, buf []byte, offset int64) (int, error) {
+	cnt := 0
+	for cnt < len(buf) {
+		r, err := unix.Pread(fd, buf[cnt:], offset+int64(cnt))
+		if errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			return cnt, err
+		}
+		if r == 0 {
+			// EOF
+			return cnt, nil
+		}
+		cnt += r
+	}
+	return cnt, nil
+}
+
+// allocAligned returns size bytes of page-aligned memory from an anonymous
+// mmap, which must be released with freeAligned.
+func allocAligned(size int) ([]byte, error) {
+	if size == 0 {
+		return []byte{}, nil
+	}
+	return unix.Mmap(-1, 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+}
+
+func freeAligned(buf []byte) {
+	if len(buf) > 0 {
+		_ = unix.Munmap(buf)
+	}
+}
@@ -0,0 +1,248 @@
+package io
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Block states of a ddrescue map file.
+const (
+	mapFinished   = '+'
+	mapBadSector  = '-'
+	mapNonTried   = '?'
+	mapNonTrimmed = '*'
+	mapNonScraped = '/'
+)
+
+// Rescue copies src to dst like CopyContext, but keeps going past read
+// errors, for salvaging data from a failing disk. A chunk that cannot be
+// read is split in halves down to single sectors; the sectors that still
+// fail are filled with zeros on dst, whatever the ZeroPolicy. Read retries
+// under opts.Retry happen before a chunk is split.
+//
+// The outcome is written to mapfile in the ddrescue map file format, with
+// the unreadable sectors marked bad. When mapfile already exists, only the
+// areas it does not mark finished are read again, so a later run retries
+// just the bad areas; ddrescue itself can also pick the map up. Those areas
+// are rounded out to whole chunks, and with opts.Verify the finished ones
+// are compared with the source. The bad ranges are reported in
+// Result.Unreadable. Checkpoints are not supported, the map file takes their
+// place.
+func Rescue(ctx context.Context, src *os.File, dst *os.File, mapfile string, opts CopyOptions) (*Result, error) {
+	if opts.Checkpoint != "" {
+		return nil, fmt.Errorf("%w: rescue copies keep a map file instead of a checkpoint", ErrInvalidOptions)
+	}
+	c, err := newFileCopier(src, dst, opts)
+	if err != nil {
+		return nil, err
+	}
+	r := &rescuer{sectorSize: rescueSectorSize(src)}
+	c.rescue = r
+
+	finished, bad, err := readMapfile(mapfile, c.size)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	finished = alignFinished(finished, c.size, uint64(c.opts.ChunkSize))
+	c.skipDone(finished)
+	if c.verify != nil {
+		c.verify.resume(finished, func(offset uint64, buf []byte) error {
+			_, err := PReadExact(src, buf, len(buf), offset)
+			return err
+		})
+	}
+
+	res, err := c.run(ctx)
+	res.Unreadable = r.unreadable()
+	// bad areas of the earlier runs that were not tried again stay bad
+	done := c.commit.finished()
+	bad, _ = subtractExtents(bad, done)
+	for _, e := range res.Unreadable {
+		bad = append(bad, extent{offset: e.Offset, length: e.Length})
+	}
+	if merr := writeMapfile(mapfile, c.size, done, bad, err == nil); err == nil {
+		err = merr
+	}
+	return res, err
+}
+
+// alignFinished rounds the areas a map leaves to read, bad ones included, out
+// to chunk boundaries and returns what remains finished, so that chunks are
+// scheduled at the offsets of a fresh run. Maps written by ddrescue track
+// single sectors. The bad areas themselves are kept as they are for the new
+// map, they are all read again anyway.
+func alignFinished(finished []extent, size, chunkSize uint64) []extent {
+	whole := []extent{{offset: 0, length: size}}
+	todo, _ := subtractExtents(whole, finished)
+	finished, _ = subtractExtents(whole, alignExtents(todo, size, chunkSize))
+	return finished
+}
+
+// rescuer salvages the readable sectors of chunks that failed to read.
+type rescuer struct {
+	sectorSize uint64
+
+	mu  sync.Mutex
+	bad []extent
+}
+
+// salvage fills buf with what can be read of chunk, zeros elsewhere.
+func (r *rescuer) salvage(ctx context.Context, c *copier, chunk extent, buf []byte) ([]byte, error) {
+	var split func(part extent, b []byte) error
+	split = func(part extent, b []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := c.read(ctx, part, b); err == nil {
+			return nil
+		} else if errors.Is(err, ctx.Err()) {
+			return err
+		}
+		if part.length <= r.sectorSize {
+			clear(b)
+			r.mu.Lock()
+			r.bad = append(r.bad, part)
+			r.mu.Unlock()
+			return nil
+		}
+		half := (part.length/2 + r.sectorSize - 1) / r.sectorSize * r.sectorSize
+		if err := split(extent{offset: part.offset, length: half}, b[:half]); err != nil {
+			return err
+		}
+		return split(extent{offset: part.offset + half, length: part.length - half}, b[half:])
+	}
+	if err := split(chunk, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// unreadable returns the merged bad ranges.
+func (r *rescuer) unreadable() []Extent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var bad []Extent
+	for _, e := range mergeExtents(r.bad) {
+		bad = append(bad, Extent{Offset: e.offset, Length: e.length})
+	}
+	return bad
+}
+
+// readMapfile returns the finished and the bad areas of a ddrescue map file.
+func readMapfile(path string, size uint64) (finished, bad []extent, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	statusLine := true
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		// the first line holds the current position, status and pass
+		if statusLine {
+			statusLine = false
+			continue
+		}
+		if len(fields) != 3 || len(fields[2]) != 1 {
+			return nil, nil, fmt.Errorf("%w: malformed line %q", ErrInvalidMapfile, line)
+		}
+		pos, err := strconv.ParseUint(fields[0], 0, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: malformed line %q", ErrInvalidMapfile, line)
+		}
+		length, err := strconv.ParseUint(fields[1], 0, 64)
+		if err != nil || pos+length > size {
+			return nil, nil, fmt.Errorf("%w: block %q is past the %d byte source", ErrInvalidMapfile, line, size)
+		}
+		switch fields[2][0] {
+		case mapFinished:
+			finished = append(finished, extent{offset: pos, length: length})
+		case mapBadSector:
+			bad = append(bad, extent{offset: pos, length: length})
+		case mapNonTried, mapNonTrimmed, mapNonScraped:
+		default:
+			return nil, nil, fmt.Errorf("%w: unknown status in %q", ErrInvalidMapfile, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return mergeExtents(finished), mergeExtents(bad), nil
+}
+
+// writeMapfile replaces the map file at path. The areas in bad are bad
+// sectors, the rest of finished is finished and anything else is not tried
+// yet.
+func writeMapfile(path string, size uint64, finished, bad []extent, complete bool) error {
+	var blocks []extent
+	for _, e := range mergeExtents(bad) {
+		// hole marks the bad blocks
+		e.hole = true
+		blocks = append(blocks, e)
+	}
+	good, _ := subtractExtents(mergeExtents(finished), blocks)
+	blocks = append(blocks, good...)
+	slices.SortFunc(blocks, func(a, b extent) int {
+		return cmp.Compare(a.offset, b.offset)
+	})
+
+	var b strings.Builder
+	b.WriteString("# Rescue map file, in the ddrescue mapfile format\n")
+	b.WriteString("# current_pos  current_status  current_pass\n")
+	status := byte(mapNonTried)
+	if complete {
+		status = mapFinished
+	}
+	fmt.Fprintf(&b, "0x%08X     %c               1\n", size, status)
+	b.WriteString("#      pos        size  status\n")
+	var pos uint64
+	line := func(start, end uint64, status byte) {
+		if start < end {
+			fmt.Fprintf(&b, "0x%08X  0x%08X  %c\n", start, end-start, status)
+		}
+	}
+	for _, blk := range blocks {
+		line(pos, blk.offset, mapNonTried)
+		status := byte(mapFinished)
+		if blk.hole {
+			status = mapBadSector
+		}
+		line(blk.offset, blk.end(), status)
+		pos = blk.end()
+	}
+	line(pos, size, mapNonTried)
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// rescueSectorSize is the granularity failed chunks are split down to: the
+// logical block size of a device or of an O_DIRECT file, 512 otherwise.
+func rescueSectorSize(f *os.File) uint64 {
+	if info, err := f.Stat(); err == nil && isBlockDevice(info) {
+		if logical, _, err := blockSizes(f); err == nil && logical > 0 {
+			return uint64(logical)
+		}
+	}
+	if bs, err := directBlockSize(f); err == nil && bs > 0 {
+		return uint64(bs)
+	}
+	return minAlignSize
+}
@@ -0,0 +1,185 @@
+package io
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *IOTestSuite) TestRescueSkipsUnreadableSectors() {
+	srcFile, data := suite.createRandomFile(1024 * 1024)
+	defer os.Remove(srcFile.Name())
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+	mapfile := filepath.Join(suite.T().TempDir(), "rescue.map")
+
+	const badOffset = 300*1024 + 700
+	opts := CopyOptions{
+		ChunkSize: 64 * 1024,
+		Faults:    NewFaultPlan(Fault{Op: FaultRead, Offset: badOffset, AtOffset: true, Err: syscall.EIO, Times: -1}),
+	}
+	res, err := Rescue(context.Background(), srcFile, dstFile, mapfile, opts)
+	suite.Require().NoError(err)
+	suite.Require().Equal([]Extent{{Offset: 300*1024 + 512, Length: 512}}, res.Unreadable)
+
+	dstData, err := os.ReadFile(dstFile.Name())
+	suite.Require().NoError(err)
+	bad := res.Unreadable[0]
+	assert.Equal(suite.T(), make([]byte, bad.Length), dstData[bad.Offset:bad.Offset+bad.Length])
+	assert.Equal(suite.T(), data[:bad.Offset], dstData[:bad.Offset])
+	assert.Equal(suite.T(), data[bad.Offset+bad.Length:], dstData[bad.Offset+bad.Length:])
+
+	content, err := os.ReadFile(mapfile)
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), []string{
+		"0x00100000     +               1",
+		"0x00000000  0x0004B200  +",
+		"0x0004B200  0x00000200  -",
+		"0x0004B400  0x000B4C00  +",
+	}, mapfileLines(content))
+
+	// a second run only reads the bad sector again
+	plan := NewFaultPlan(Fault{Op: FaultRead, Times: -1, Latency: 1})
+	opts.Faults = plan
+	res, err = Rescue(context.Background(), srcFile, dstFile, mapfile, opts)
+	suite.Require().NoError(err)
+	assert.Empty(suite.T(), res.Unreadable)
+	assert.Equal(suite.T(), 1, plan.Hits())
+
+	dstData, err = os.ReadFile(dstFile.Name())
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), data, dstData)
+
+	content, err = os.ReadFile(mapfile)
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), []string{
+		"0x00100000     +               1",
+		"0x00000000  0x00100000  +",
+	}, mapfileLines(content))
+}
+
+func (suite *IOTestSuite) TestRescueOverwritesStaleData() {
+	// a zero chunk with a bad sector salvages to all zeros
+	srcFile, data := suite.createRandomFile(256 * 1024)
+	defer os.Remove(srcFile.Name())
+	clear(data[64*1024 : 128*1024])
+	_, err := srcFile.WriteAt(data[64*1024:128*1024], 64*1024)
+	suite.Require().NoError(err)
+
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+	_, err = dstFile.WriteAt(bytes.Repeat([]byte{0xee}, len(data)), 0)
+	suite.Require().NoError(err)
+
+	opts := CopyOptions{
+		ChunkSize:  64 * 1024,
+		ZeroPolicy: ZeroSkip,
+		Faults:     NewFaultPlan(Fault{Op: FaultRead, Offset: 100 * 1024, AtOffset: true, Err: syscall.EIO, Times: -1}),
+	}
+	res, err := Rescue(context.Background(), srcFile, dstFile, filepath.Join(suite.T().TempDir(), "rescue.map"), opts)
+	suite.Require().NoError(err)
+	suite.Require().Equal([]Extent{{Offset: 100 * 1024, Length: 512}}, res.Unreadable)
+
+	dstData, err := os.ReadFile(dstFile.Name())
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), data, dstData)
+}
+
+func (suite *IOTestSuite) TestRescueRerunVerify() {
+	srcFile, data := suite.createRandomFile(1024 * 1024)
+	defer os.Remove(srcFile.Name())
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+	mapfile := filepath.Join(suite.T().TempDir(), "rescue.map")
+
+	// the bad sector leaves a map that is not chunk aligned
+	opts := CopyOptions{
+		ChunkSize: 64 * 1024,
+		Verify:    true,
+		Faults:    NewFaultPlan(Fault{Op: FaultRead, Offset: 300*1024 + 700, AtOffset: true, Err: syscall.EIO, Times: -1}),
+	}
+	res, err := Rescue(context.Background(), srcFile, dstFile, mapfile, opts)
+	suite.Require().NoError(err)
+	suite.Require().Equal([]Extent{{Offset: 300*1024 + 512, Length: 512}}, res.Unreadable)
+
+	// the rerun reads the whole chunk around it and checks the rest
+	plan := NewFaultPlan(Fault{Op: FaultRead, Times: -1, Latency: 1})
+	opts.Faults = plan
+	res, err = Rescue(context.Background(), srcFile, dstFile, mapfile, opts)
+	suite.Require().NoError(err)
+	assert.Empty(suite.T(), res.Mismatches)
+	assert.Equal(suite.T(), 1, plan.Hits())
+	assert.Equal(suite.T(), uint64(64*1024), res.BytesRead)
+
+	dstData, err := os.ReadFile(dstFile.Name())
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), data, dstData)
+}
+
+func (suite *IOTestSuite) TestRescueKeepsUntriedBadAreas() {
+	srcFile, _ := suite.createRandomFile(256 * 1024)
+	defer os.Remove(srcFile.Name())
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+	mapfile := filepath.Join(suite.T().TempDir(), "rescue.map")
+
+	// a map left by an earlier run, or by ddrescue
+	suite.Require().NoError(os.WriteFile(mapfile, []byte(strings.Join([]string{
+		"# Mapfile. Created by GNU ddrescue",
+		"0x00010000     ?               1",
+		"#      pos        size  status",
+		"0x00000000  0x00010000  +",
+		"0x00010000  0x00001000  -",
+		"0x00011000  0x0002F000  ?",
+	}, "\n")+"\n"), 0600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = Rescue(ctx, srcFile, dstFile, mapfile, CopyOptions{ChunkSize: 64 * 1024})
+	suite.Require().Error(err)
+
+	content, err := os.ReadFile(mapfile)
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), []string{
+		"0x00040000     ?               1",
+		"0x00000000  0x00010000  +",
+		"0x00010000  0x00001000  -",
+		"0x00011000  0x0002F000  ?",
+	}, mapfileLines(content))
+}
+
+func (suite *IOTestSuite) TestRescueInvalidMapfile() {
+	srcFile, _ := suite.createRandomFile(64 * 1024)
+	defer os.Remove(srcFile.Name())
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+	mapfile := filepath.Join(suite.T().TempDir(), "rescue.map")
+
+	suite.Require().NoError(os.WriteFile(mapfile, []byte("0x0 ? 1\n0x00000000  0x00100000  +\n"), 0600))
+	_, err = Rescue(context.Background(), srcFile, dstFile, mapfile, CopyOptions{})
+	assert.ErrorIs(suite.T(), err, ErrInvalidMapfile)
+
+	_, err = Rescue(context.Background(), srcFile, dstFile, mapfile, CopyOptions{Checkpoint: mapfile})
+	assert.ErrorIs(suite.T(), err, ErrInvalidOptions)
+}
+
+// mapfileLines returns the lines of a map file without its comments.
+func mapfileLines(content []byte) []string {
+	var lines []string
+	for _, line := range bytes.Split(bytes.TrimSpace(content), []byte("\n")) {
+		if !bytes.HasPrefix(line, []byte("#")) {
+			lines = append(lines, string(line))
+		}
+	}
+	return lines
+}
@@ -0,0 +1,36 @@
+package io
+
+// Result describes how a Copy or Write run went. It is returned even when the
+// run stops on an error, describing the work done until then.
+type Result struct {
+	// Stats sums up the work done.
+	Stats
+
+	// ZeroPolicy is the zero policy that was actually applied. It differs
+	// from the requested one when the destination does not support it.
+	ZeroPolicy ZeroPolicy
+
+	// Digest is the hash of the whole destination image, set when Verify
+	// is requested and the destination matches the source.
+	Digest []byte
+	// Mismatches holds the offsets of the chunks whose destination content
+	// differs from the source.
+	Mismatches []uint64
+
+	// ChunksChanged and ChunksUnchanged count the chunks DiffCopy wrote
+	// and the ones it left alone because the destination already matched.
+	ChunksChanged   uint64
+	ChunksUnchanged uint64
+
+	// Compression is the format WriteDecompressed detected on its source.
+	Compression Compression
+
+	// Failures lists every chunk that failed, in the order the failures
+	// were seen. The first one is the error the run returned; the others
+	// were in flight when the run stopped.
+	Failures []*IOError
+
+	// Unreadable lists the source ranges Rescue could not read. They were
+	// filled with zeros on the destination.
+	Unreadable []Extent
+}
@@ -0,0 +1,138 @@
+package io
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	defaultRetryBackoff    = 100 * time.Millisecond
+	defaultRetryMaxBackoff = 10 * time.Second
+	defaultRetryJitter     = 0.2
+)
+
+// retryableErrnos are the errors transports like iSCSI and NVMe-oF return
+// for a hiccup that a later attempt may not hit.
+var retryableErrnos = []unix.Errno{
+	unix.EIO,
+	unix.EAGAIN,
+	unix.EBUSY,
+	unix.ETIMEDOUT,
+	unix.EREMOTEIO,
+	unix.ENOLINK,
+}
+
+// RetryPolicy retries chunks whose read or write failed with a transient
+// error, instead of failing the whole run. The zero value never retries.
+type RetryPolicy struct {
+	// MaxAttempts is how many times a chunk is tried, the first attempt
+	// included. Zero and one disable retries.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry, doubled after
+	// every further attempt. It defaults to 100ms.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between attempts. It defaults to 10s, or to
+	// InitialBackoff when that is longer.
+	MaxBackoff time.Duration
+	// Jitter spreads every wait randomly by up to this fraction of it in
+	// either direction, so that workers failing together do not retry in
+	// lockstep. It must be between 0 and 1 and defaults to 0.2; use a
+	// negative value for no jitter.
+	Jitter float64
+	// Retryable decides which errors are transient. It defaults to
+	// IsRetryable.
+	Retryable func(error) bool
+}
+
+// IsRetryable reports whether err is one of the errnos that transient
+// device or transport failures show up as: EIO, EAGAIN, EBUSY, ETIMEDOUT,
+// EREMOTEIO and ENOLINK.
+func IsRetryable(err error) bool {
+	var errno unix.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	for _, e := range retryableErrnos {
+		if errno == e {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *RetryPolicy) setDefaults() {
+	if p.MaxAttempts <= 1 {
+		return
+	}
+	if p.InitialBackoff == 0 {
+		p.InitialBackoff = defaultRetryBackoff
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = max(defaultRetryMaxBackoff, p.InitialBackoff)
+	}
+	if p.Jitter == 0 {
+		p.Jitter = defaultRetryJitter
+	}
+	if p.Jitter < 0 {
+		p.Jitter = 0
+	}
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsRetryable(err)
+}
+
+func (p *RetryPolicy) validate() error {
+	if p.MaxAttempts < 0 {
+		return fmt.Errorf("%w: retry attempts must not be negative, got %d", ErrInvalidOptions, p.MaxAttempts)
+	}
+	// without retries the backoff is never waited for
+	if p.MaxAttempts > 1 && (p.InitialBackoff < 0 || p.MaxBackoff < p.InitialBackoff) {
+		return fmt.Errorf("%w: retry backoff must be positive and below the max backoff", ErrInvalidOptions)
+	}
+	if p.Jitter > 1 {
+		return fmt.Errorf("%w: retry jitter must be between 0 and 1, got %v", ErrInvalidOptions, p.Jitter)
+	}
+	return nil
+}
+
+// backoff returns the wait before retry number n, counted from 1.
+func (p *RetryPolicy) backoff(n int) time.Duration {
+	wait := p.InitialBackoff
+	for i := 1; i < n && wait < p.MaxBackoff; i++ {
+		wait *= 2
+	}
+	wait = min(wait, p.MaxBackoff)
+	if p.Jitter > 0 {
+		wait = time.Duration(float64(wait) * (1 + p.Jitter*(2*rand.Float64()-1)))
+	}
+	return wait
+}
+
+// retry runs fn until it succeeds, fails with an error the policy does not
+// retry, runs out of attempts or ctx is done.
+func (c *copier) retry(ctx context.Context, fn func() error) error {
+	policy := &c.opts.Retry
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= policy.MaxAttempts || !policy.retryable(err) {
+			return err
+		}
+		timer := time.NewTimer(policy.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+		c.counters.retries.Add(1)
+	}
+}
@@ -0,0 +1,112 @@
+package io
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *IOTestSuite) TestRetryTransientErrors() {
+	srcFile, data := suite.createRandomFile(1024 * 1024)
+	defer os.Remove(srcFile.Name())
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	plan := NewFaultPlan(
+		Fault{Op: FaultWrite, Offset: 128 * 1024, AtOffset: true, Err: syscall.EIO, Times: 2},
+		Fault{Op: FaultRead, Offset: 512 * 1024, AtOffset: true, Err: syscall.EAGAIN},
+	)
+	opts := CopyOptions{
+		ChunkSize: 64 * 1024,
+		Retry:     RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+		Faults:    plan,
+	}
+	res, err := CopyContext(context.Background(), srcFile, dstFile, opts)
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), uint64(3), res.Retries)
+	assert.Equal(suite.T(), 3, plan.Hits())
+
+	dstData, err := os.ReadFile(dstFile.Name())
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), data, dstData)
+}
+
+func (suite *IOTestSuite) TestRetryGivesUp() {
+	srcFile, _ := suite.createRandomFile(1024 * 1024)
+	defer os.Remove(srcFile.Name())
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	opts := CopyOptions{
+		ChunkSize: 64 * 1024,
+		Readers:   1,
+		Writers:   1,
+		Retry:     RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+	}
+
+	// a persistent error fails after the last attempt
+	opts.Faults = NewFaultPlan(Fault{Op: FaultWrite, AtOffset: true, Err: syscall.EIO, Times: -1})
+	res, err := CopyContext(context.Background(), srcFile, dstFile, opts)
+	assert.ErrorIs(suite.T(), err, syscall.EIO)
+	assert.Equal(suite.T(), uint64(2), res.Retries)
+
+	// errors that are not transient are not retried
+	opts.Faults = NewFaultPlan(Fault{Op: FaultWrite, Err: syscall.ENOSPC})
+	res, err = CopyContext(context.Background(), srcFile, dstFile, opts)
+	assert.ErrorIs(suite.T(), err, syscall.ENOSPC)
+	assert.Zero(suite.T(), res.Retries)
+
+	// unless the policy says so
+	opts.Faults = NewFaultPlan(Fault{Op: FaultWrite, Err: syscall.ENOSPC})
+	opts.Retry.Retryable = func(err error) bool { return errors.Is(err, syscall.ENOSPC) }
+	res, err = CopyContext(context.Background(), srcFile, dstFile, opts)
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), uint64(1), res.Retries)
+}
+
+func (suite *IOTestSuite) TestRetryBackoff() {
+	policy := RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Second, MaxBackoff: 5 * time.Second, Jitter: -1}
+	policy.setDefaults()
+	suite.Require().NoError(policy.validate())
+	assert.Equal(suite.T(), time.Second, policy.backoff(1))
+	assert.Equal(suite.T(), 2*time.Second, policy.backoff(2))
+	assert.Equal(suite.T(), 4*time.Second, policy.backoff(3))
+	assert.Equal(suite.T(), 5*time.Second, policy.backoff(4))
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		wait := policy.backoff(1)
+		assert.GreaterOrEqual(suite.T(), wait, 500*time.Millisecond)
+		assert.LessOrEqual(suite.T(), wait, 1500*time.Millisecond)
+	}
+
+	assert.True(suite.T(), IsRetryable(&IOError{Op: OpRead, Err: syscall.ETIMEDOUT}))
+	assert.False(suite.T(), IsRetryable(&IOError{Op: OpRead, Err: syscall.ENOSPC}))
+	assert.False(suite.T(), IsRetryable(context.Canceled))
+}
+
+func (suite *IOTestSuite) TestRetryPolicyDefaults() {
+	// backoffs do not matter while retries are disabled
+	policy := RetryPolicy{InitialBackoff: time.Second}
+	policy.setDefaults()
+	assert.NoError(suite.T(), policy.validate())
+
+	// the max backoff defaults to no less than the initial one
+	policy = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Minute}
+	policy.setDefaults()
+	suite.Require().NoError(policy.validate())
+	assert.Equal(suite.T(), time.Minute, policy.MaxBackoff)
+	policy = RetryPolicy{MaxAttempts: 3}
+	policy.setDefaults()
+	assert.Equal(suite.T(), defaultRetryMaxBackoff, policy.MaxBackoff)
+
+	policy = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Minute, MaxBackoff: time.Second}
+	policy.setDefaults()
+	assert.ErrorIs(suite.T(), policy.validate(), ErrInvalidOptions)
+}
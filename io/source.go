@@ -0,0 +1,65 @@
+package io
+
+import (
+	"context"
+	"fmt"
+	stdio "io"
+	"os"
+)
+
+// Extent is a range of an image that holds data.
+type Extent struct {
+	Offset uint64
+	Length uint64
+}
+
+// Source is an image format that can be read in its guest view, such as a
+// Qcow2Image.
+type Source interface {
+	stdio.ReaderAt
+	// Size returns the size of the guest view.
+	Size() uint64
+	// Extents returns the sorted ranges that may hold data. Everything
+	// else reads as zeros and is never read.
+	Extents() ([]Extent, error)
+}
+
+// CopyFrom copies the guest view of src to the same offsets of dst, reading
+// only the extents src reports. It runs the same pipeline as CopyContext and
+// follows opts likewise, except that checkpoints are not supported.
+func CopyFrom(ctx context.Context, src Source, dst *os.File, opts CopyOptions) (*Result, error) {
+	opts.setDefaults()
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if opts.Checkpoint != "" {
+		return nil, fmt.Errorf("%w: checkpoints are not supported for image sources", ErrInvalidOptions)
+	}
+	if err := checkDirectIO(opts, dst); err != nil {
+		return nil, err
+	}
+
+	srcExtents, err := src.Extents()
+	if err != nil {
+		return nil, err
+	}
+	extents := make([]extent, 0, len(srcExtents))
+	for _, e := range srcExtents {
+		extents = append(extents, extent{offset: e.Offset, length: e.Length})
+	}
+
+	size := src.Size()
+	c := newCopier(dst, size, opts)
+	c.setExtents(alignExtents(extents, size, uint64(opts.ChunkSize)))
+	if err := c.preflight(nil, 0); err != nil {
+		return nil, err
+	}
+	c.pooled = true
+	c.fill = func(offset, count uint64, buf []byte) ([]byte, error) {
+		if n, err := src.ReadAt(buf[:count], int64(offset)); n < int(count) {
+			return nil, shortReadErr(err)
+		}
+		return buf, nil
+	}
+	return c.run(ctx)
+}
@@ -0,0 +1,77 @@
+package io
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats sums up the work done by a Copy or Write run.
+type Stats struct {
+	// BytesRead is the number of source bytes read.
+	BytesRead uint64
+	// BytesWritten is the number of bytes written to the destination,
+	// including zeros written by ZeroWrite.
+	BytesWritten uint64
+	// Chunks is the number of chunks the run went through, zero chunks and
+	// scheduled holes included.
+	Chunks uint64
+	// ZeroChunks is the number of all-zero chunks, which were skipped or
+	// zeroed according to the ZeroPolicy instead of being written.
+	ZeroChunks uint64
+	// Retries counts the chunk reads and writes that were tried again
+	// under the RetryPolicy.
+	Retries uint64
+
+	// ReadTime and WriteTime are the time spent in source reads and in
+	// destination writes, summed over all readers and writers.
+	ReadTime  time.Duration
+	WriteTime time.Duration
+	// TransferTime is the wall time of moving the chunks, FinishTime of
+	// completing the destination afterwards, such as zeroing its tail, and
+	// VerifyTime of reading it back. Duration covers the whole run.
+	TransferTime time.Duration
+	FinishTime   time.Duration
+	VerifyTime   time.Duration
+	Duration     time.Duration
+	// SyncTime is the time spent making the data durable, the periodic
+	// syncs during the transfer included.
+	SyncTime time.Duration
+}
+
+// Throughput returns the average read rate of the transfer in bytes per
+// second.
+func (s Stats) Throughput() float64 {
+	if s.TransferTime <= 0 {
+		return 0
+	}
+	return float64(s.BytesRead) / s.TransferTime.Seconds()
+}
+
+// timer adds the time since it was started to an atomic nanosecond total.
+type timer struct {
+	start time.Time
+}
+
+func startTimer() timer {
+	return timer{start: time.Now()}
+}
+
+func (t timer) stop(total *atomic.Int64) {
+	total.Add(int64(time.Since(t.start)))
+}
+
+// add sums o into s.
+func (s *Stats) add(o Stats) {
+	s.BytesRead += o.BytesRead
+	s.BytesWritten += o.BytesWritten
+	s.Chunks += o.Chunks
+	s.ZeroChunks += o.ZeroChunks
+	s.Retries += o.Retries
+	s.ReadTime += o.ReadTime
+	s.WriteTime += o.WriteTime
+	s.TransferTime += o.TransferTime
+	s.FinishTime += o.FinishTime
+	s.VerifyTime += o.VerifyTime
+	s.Duration += o.Duration
+	s.SyncTime += o.SyncTime
+}
@@ -0,0 +1,51 @@
+package io
+
+import (
+	"context"
+	"os"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *IOTestSuite) TestCopyStats() {
+	srcFile, data := suite.createRandomFile(1024 * 1024)
+	defer os.Remove(srcFile.Name())
+	// two zero chunks
+	clear(data[64*1024 : 192*1024])
+	_, err := srcFile.WriteAt(data, 0)
+	suite.Require().NoError(err)
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	res, err := CopyContext(context.Background(), srcFile, dstFile, CopyOptions{ChunkSize: 64 * 1024, Verify: true})
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), uint64(1024*1024), res.BytesRead)
+	assert.Equal(suite.T(), uint64(14*64*1024), res.BytesWritten)
+	assert.Equal(suite.T(), uint64(16), res.Chunks)
+	assert.Equal(suite.T(), uint64(2), res.ZeroChunks)
+	assert.Zero(suite.T(), res.Retries)
+
+	assert.Positive(suite.T(), res.ReadTime)
+	assert.Positive(suite.T(), res.WriteTime)
+	assert.Positive(suite.T(), res.TransferTime)
+	assert.Positive(suite.T(), res.VerifyTime)
+	assert.GreaterOrEqual(suite.T(), res.Duration, res.TransferTime+res.FinishTime+res.VerifyTime)
+	assert.Positive(suite.T(), res.Throughput())
+}
+
+func (suite *IOTestSuite) TestWriteStats() {
+	data := make([]byte, 256*1024)
+	copy(data, "harvester")
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	res, err := WriteContext(context.Background(), dstFile, data, uint64(len(data)), CopyOptions{ChunkSize: 64 * 1024})
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), uint64(len(data)), res.BytesRead)
+	assert.Equal(suite.T(), uint64(64*1024), res.BytesWritten)
+	assert.Equal(suite.T(), uint64(4), res.Chunks)
+	assert.Equal(suite.T(), uint64(3), res.ZeroChunks)
+	assert.Zero(suite.T(), res.VerifyTime)
+}
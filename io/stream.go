@@ -0,0 +1,75 @@
+package io
+
+import (
+	"context"
+	"fmt"
+	stdio "io"
+	"math"
+	"os"
+)
+
+// WriteFromReader writes everything r yields to dst, for sources that cannot
+// be seeked such as HTTP bodies, stdin or decompressors. A single producer
+// cuts the stream into chunks of opts.ChunkSize, so memory stays bounded by
+// the buffer pool, while the writes are spread across the workers. Zero
+// chunks are handled according to opts.ZeroPolicy, and the last chunk may be
+// shorter than the others. A regular file dst is grown to the length of the
+// stream even when it ends in zero chunks that were skipped.
+//
+// A stream cannot be resumed, so checkpoints are not supported. Progress
+// reports carry a Total of 0.
+func WriteFromReader(ctx context.Context, dst *os.File, r stdio.Reader, opts CopyOptions) (*Result, error) {
+	opts.setDefaults()
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if opts.Checkpoint != "" {
+		return nil, fmt.Errorf("%w: checkpoints are not supported for streams", ErrInvalidOptions)
+	}
+	if err := checkDirectIO(opts, dst); err != nil {
+		return nil, err
+	}
+
+	// the size is unknown until the stream ends, chunks are read in order
+	c := newCopier(dst, math.MaxUint64, opts)
+	c.streaming = true
+	if err := c.preflight(nil, 0); err != nil {
+		return nil, err
+	}
+	c.readers = 1
+	c.pooled = true
+	var eof bool
+	c.fill = func(offset, count uint64, buf []byte) ([]byte, error) {
+		if eof {
+			return nil, stdio.EOF
+		}
+		n, err := readChunk(r, buf[:count])
+		if err == stdio.EOF {
+			eof = true
+			c.size = offset + uint64(n)
+			if n == 0 {
+				return nil, stdio.EOF
+			}
+		} else if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+	return c.run(ctx)
+}
+
+// readChunk fills buf from r like io.ReadFull, but returns io.EOF along with
+// a short count at the end of the stream. Unlike io.ReadFull it passes an
+// io.ErrUnexpectedEOF from r through, so that a truncated compressed stream
+// is not mistaken for the end of the image.
+func readChunk(r stdio.Reader, buf []byte) (int, error) {
+	var n int
+	for n < len(buf) {
+		m, err := r.Read(buf[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,92 @@
+package io
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	stdio "io"
+	"os"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *IOTestSuite) TestWriteFromReader() {
+	// a zero range in the middle and an unaligned tail
+	data := make([]byte, 3*1024*1024+777)
+	_, err := rand.Read(data)
+	suite.Require().NoError(err)
+	clear(data[1024*1024 : 2*1024*1024])
+
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	// a pipe hides the size and rules out seeking
+	pr, pw := stdio.Pipe()
+	go func() {
+		_, err := pw.Write(data)
+		pw.CloseWithError(err)
+	}()
+
+	var last Progress
+	res, err := WriteFromReader(context.Background(), dstFile, pr, CopyOptions{
+		ChunkSize: 64 * 1024,
+		Verify:    true,
+		Progress:  func(p Progress) { last = p },
+	})
+	suite.Require().NoError(err)
+	assert.NotEmpty(suite.T(), res.Digest)
+	assert.Equal(suite.T(), uint64(len(data)), last.BytesRead)
+	assert.Equal(suite.T(), uint64(16), last.ZeroChunks)
+
+	dstData, err := os.ReadFile(dstFile.Name())
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), data, dstData, "Data should be the same")
+}
+
+func (suite *IOTestSuite) TestWriteFromReaderZeroTail() {
+	// only the first chunk holds data, the unaligned rest is zeros
+	data := make([]byte, 1024*1024+777)
+	_, err := rand.Read(data[:64*1024])
+	suite.Require().NoError(err)
+
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	res, err := WriteFromReader(context.Background(), dstFile, bytes.NewReader(data), CopyOptions{ChunkSize: 64 * 1024})
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), ZeroSkip, res.ZeroPolicy)
+
+	dstData, err := os.ReadFile(dstFile.Name())
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), data, dstData)
+}
+
+func (suite *IOTestSuite) TestWriteFromReaderEmpty() {
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	_, err = WriteFromReader(context.Background(), dstFile, bytes.NewReader(nil), CopyOptions{ZeroPolicy: ZeroWrite})
+	suite.Require().NoError(err)
+	info, err := dstFile.Stat()
+	suite.Require().NoError(err)
+	assert.Zero(suite.T(), info.Size())
+}
+
+func (suite *IOTestSuite) TestWriteFromReaderError() {
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	readErr := errors.New("connection reset")
+	r := stdio.MultiReader(bytes.NewReader(make([]byte, 100*1024)), iotest.ErrReader(readErr))
+	_, err = WriteFromReader(context.Background(), dstFile, r, CopyOptions{ChunkSize: 64 * 1024})
+	assert.ErrorIs(suite.T(), err, readErr)
+
+	_, err = WriteFromReader(context.Background(), dstFile, r, CopyOptions{Checkpoint: dstFile.Name() + ".journal"})
+	assert.ErrorIs(suite.T(), err, ErrInvalidOptions)
+}
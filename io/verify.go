@@ -0,0 +1,128 @@
+package io
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	_ "crypto/sha256" // register the default VerifyHash
+	_ "crypto/sha512"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// verifier remembers a hash of every source chunk as the producers read it,
+// then reads the destination back and compares.
+type verifier struct {
+	hash crypto.Hash
+
+	mu sync.Mutex
+	// sums maps chunk offsets to their hash, nil for zero chunks
+	sums map[uint64][]byte
+	// resumed are the ranges copied by an earlier run, which have no sums
+	// and are compared with the source read through readSource instead
+	resumed    []extent
+	readSource func(offset uint64, buf []byte) error
+
+	digest     []byte
+	mismatches []uint64
+}
+
+func newVerifier(hash crypto.Hash) *verifier {
+	if hash == 0 {
+		hash = crypto.SHA256
+	}
+	return &verifier{hash: hash, sums: map[uint64][]byte{}}
+}
+
+func (v *verifier) record(obj Content) {
+	var sum []byte
+	if !obj.zero {
+		h := v.hash.New()
+		h.Write(obj.buf)
+		sum = h.Sum(nil)
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.sums[obj.offset] = sum
+}
+
+// resume makes run compare the sorted ranges in done, copied by an earlier
+// run, with the source read through readSource.
+func (v *verifier) resume(done []extent, readSource func(offset uint64, buf []byte) error) {
+	v.resumed, v.readSource = done, readSource
+}
+
+// isResumed reports whether [offset, offset+count) overlaps a resumed range.
+func (v *verifier) isResumed(offset, count uint64) bool {
+	for _, e := range v.resumed {
+		if e.offset < offset+count && offset < e.end() {
+			return true
+		}
+	}
+	return false
+}
+
+// run reads [base, base+size) of dst back with PReadExact. Chunks that were
+// never scheduled are source holes and must read back as zeros. Bytes past
+// the end of a shorter regular file read as zeros as well. Mismatches are
+// reported at their dst offsets.
+func (v *verifier) run(ctx context.Context, dst *os.File, base, size uint64, chunkSize int) error {
+	avail := size
+	if info, err := dst.Stat(); err == nil && info.Mode().IsRegular() && uint64(info.Size()) < base+size {
+		avail = uint64(max(info.Size()-int64(base), 0))
+	}
+
+	image := v.hash.New()
+	buf := make([]byte, chunkSize)
+	var srcBuf []byte
+	if v.readSource != nil {
+		srcBuf = make([]byte, chunkSize)
+	}
+	for offset := uint64(0); offset < size; offset += uint64(chunkSize) {
+		if err := ctx.Err(); err != nil {
+			return &CancelError{Offset: size, Err: err}
+		}
+		count := min(uint64(chunkSize), size-offset)
+		chunk := buf[:count]
+		clear(chunk)
+		if offset < avail {
+			n := min(count, avail-offset)
+			if _, err := PReadExact(dst, chunk, int(n), base+offset); err != nil {
+				return err
+			}
+		}
+		image.Write(chunk)
+
+		v.mu.Lock()
+		sum, recorded := v.sums[offset]
+		v.mu.Unlock()
+		if !recorded && v.isResumed(offset, count) {
+			if err := v.readSource(offset, srcBuf[:count]); err != nil {
+				return err
+			}
+			if !bytes.Equal(chunk, srcBuf[:count]) {
+				v.mismatches = append(v.mismatches, base+offset)
+			}
+			continue
+		}
+		if !v.matches(chunk, sum) {
+			v.mismatches = append(v.mismatches, base+offset)
+		}
+	}
+
+	if len(v.mismatches) > 0 {
+		return fmt.Errorf("%w: %d chunks differ", ErrVerifyMismatch, len(v.mismatches))
+	}
+	v.digest = image.Sum(nil)
+	return nil
+}
+
+func (v *verifier) matches(chunk, sum []byte) bool {
+	if sum == nil {
+		return isZeroChunk(chunk)
+	}
+	h := v.hash.New()
+	h.Write(chunk)
+	return bytes.Equal(h.Sum(nil), sum)
+}
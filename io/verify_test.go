@@ -0,0 +1,70 @@
+package io
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"os"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *IOTestSuite) TestCopyVerify() {
+	srcFile, data := suite.createSparseFile()
+	defer os.Remove(srcFile.Name())
+
+	// Create a temporary dstFile for testing
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	res, err := CopyContext(context.Background(), srcFile, dstFile, CopyOptions{ChunkSize: 1024 * 1024, Verify: true})
+	suite.Require().NoError(err)
+	sum := sha256.Sum256(data)
+	assert.Equal(suite.T(), sum[:], res.Digest)
+	assert.Empty(suite.T(), res.Mismatches)
+}
+
+func (suite *IOTestSuite) TestCopyVerifyMismatch() {
+	srcFile, data := suite.createRandomFile(1024 * 1024)
+	defer os.Remove(srcFile.Name())
+
+	// with one zero chunk
+	clear(data[8192:12288])
+	_, err := srcFile.WriteAt(data, 0)
+	suite.Require().NoError(err)
+
+	// The stale destination is left in place by ZeroSkip
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+	_, err = dstFile.WriteAt(bytes.Repeat([]byte{0xff}, len(data)), 0)
+	suite.Require().NoError(err)
+
+	res, err := CopyContext(context.Background(), srcFile, dstFile, CopyOptions{ChunkSize: 4096, Verify: true})
+	assert.ErrorIs(suite.T(), err, ErrVerifyMismatch)
+	suite.Require().NotNil(res)
+	assert.Equal(suite.T(), []uint64{8192}, res.Mismatches)
+	assert.Nil(suite.T(), res.Digest)
+}
+
+func (suite *IOTestSuite) TestWriteVerifySHA512() {
+	// Create a temporary dstFile for testing
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+
+	// Generate random data with an unaligned tail
+	data := make([]byte, 5*1024*1024+777)
+	_, err = rand.Read(data)
+	suite.Require().NoError(err)
+
+	opts := CopyOptions{ChunkSize: 4096, Verify: true, VerifyHash: crypto.SHA512}
+	res, err := WriteContext(context.Background(), dstFile, data, uint64(len(data)), opts)
+	suite.Require().NoError(err)
+	sum := sha512.Sum512(data)
+	assert.Equal(suite.T(), sum[:], res.Digest)
+}
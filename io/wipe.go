@@ -0,0 +1,198 @@
+package io
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	mrand "math/rand/v2"
+	"os"
+)
+
+// WipeMode selects how Wipe overwrites the destination.
+type WipeMode int
+
+const (
+	// WipeZero writes zeros once.
+	WipeZero WipeMode = iota
+	// WipeRandom writes random data once.
+	WipeRandom
+	// WipePatterns writes every pattern of WipeOptions.Patterns in turn,
+	// one pass each.
+	WipePatterns
+	// WipeDiscard discards the whole device with BLKSECDISCARD, or with
+	// BLKDISCARD when secure discards are not supported. Destinations that
+	// support neither, regular files included, get a zero pass instead.
+	WipeDiscard
+)
+
+func (m WipeMode) String() string {
+	switch m {
+	case WipeZero:
+		return "zero"
+	case WipeRandom:
+		return "random"
+	case WipePatterns:
+		return "patterns"
+	case WipeDiscard:
+		return "discard"
+	default:
+		return fmt.Sprintf("WipeMode(%d)", int(m))
+	}
+}
+
+// defaultWipePatterns are the passes of WipePatterns when no patterns are
+// given: alternating bits both ways, a repeating 1001 bit run and zeros.
+var defaultWipePatterns = [][]byte{{0x55}, {0xaa}, {0x92, 0x49, 0x24}, {0x00}}
+
+// WipeOptions tunes a Wipe. The CopyOptions apply to every pass; ZeroPolicy
+// and Checkpoint must be left unset.
+type WipeOptions struct {
+	CopyOptions
+	// Mode defaults to WipeZero.
+	Mode WipeMode
+	// Patterns are the byte sequences written by WipePatterns, each
+	// repeated over the whole destination.
+	Patterns [][]byte
+}
+
+// Wipe overwrites the whole of dst, a device or a regular file, to sanitize
+// it. The passes run through the same readers and writers as a copy, and
+// every pass is synced before the next one starts so that they all reach
+// the disk; opts.Durability can only raise that. With opts.Verify the last
+// pass is read back and compared, which WipeDiscard does not support as
+// discarded blocks have no defined content.
+//
+// Progress reports carry the pass they belong to. The returned Result is the
+// one of the last pass run, with the Stats of all passes added up.
+func Wipe(ctx context.Context, dst *os.File, opts WipeOptions) (*Result, error) {
+	if opts.Checkpoint != "" || opts.ZeroPolicy != ZeroSkip {
+		return nil, fmt.Errorf("%w: wipes take neither a checkpoint nor a zero policy", ErrInvalidOptions)
+	}
+	if opts.Mode < WipeZero || opts.Mode > WipeDiscard {
+		return nil, fmt.Errorf("%w: unknown wipe mode %d", ErrInvalidOptions, opts.Mode)
+	}
+	if opts.Mode == WipeDiscard && opts.Verify {
+		return nil, fmt.Errorf("%w: discarded blocks cannot be verified", ErrInvalidOptions)
+	}
+	passes, err := wipePasses(opts)
+	if err != nil {
+		return nil, err
+	}
+	size, err := getSourceVolSize(dst)
+	if err != nil {
+		return nil, fmt.Errorf("error getting file size: %w", err)
+	}
+
+	var res *Result
+	var stats Stats
+	for i, pass := range passes {
+		passOpts := opts.CopyOptions
+		passOpts.Verify = opts.Verify && i == len(passes)-1
+		passOpts.Durability = max(passOpts.Durability, DurabilitySync)
+		if progress := opts.Progress; progress != nil {
+			passOpts.Progress = func(p Progress) {
+				p.Pass, p.Passes = i+1, len(passes)
+				progress(p)
+			}
+		}
+		c, err := newWipeCopier(dst, size, passOpts, pass)
+		if err != nil {
+			return res, err
+		}
+		res, err = c.run(ctx)
+		stats.add(res.Stats)
+		res.Stats = stats
+		if err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// wipePass fills the chunks of a pass, nil for a discard pass.
+type wipePass func(offset uint64, buf []byte)
+
+func wipePasses(opts WipeOptions) ([]wipePass, error) {
+	switch opts.Mode {
+	case WipeRandom:
+		var seed [32]byte
+		if _, err := rand.Read(seed[:]); err != nil {
+			return nil, err
+		}
+		return []wipePass{randomPass(seed)}, nil
+	case WipePatterns:
+		patterns := opts.Patterns
+		if len(patterns) == 0 {
+			patterns = defaultWipePatterns
+		}
+		passes := make([]wipePass, 0, len(patterns))
+		for _, pattern := range patterns {
+			if len(pattern) == 0 {
+				return nil, fmt.Errorf("%w: wipe patterns must not be empty", ErrInvalidOptions)
+			}
+			passes = append(passes, patternPass(pattern))
+		}
+		return passes, nil
+	case WipeDiscard:
+		return []wipePass{nil}, nil
+	default:
+		return []wipePass{patternPass([]byte{0})}, nil
+	}
+}
+
+// patternPass repeats pattern from offset 0 of the destination on.
+func patternPass(pattern []byte) wipePass {
+	return func(offset uint64, buf []byte) {
+		start := offset % uint64(len(pattern))
+		n := copy(buf, pattern[start:])
+		n += copy(buf[n:], pattern[:start])
+		for n < len(buf) {
+			n += copy(buf[n:], buf[:n])
+		}
+	}
+}
+
+// randomPass fills every chunk from a ChaCha8 stream keyed by seed and the
+// chunk offset, so that chunks can be generated concurrently.
+func randomPass(seed [32]byte) wipePass {
+	return func(offset uint64, buf []byte) {
+		key := seed
+		binary.LittleEndian.PutUint64(key[24:], binary.LittleEndian.Uint64(key[24:])^offset)
+		_, _ = mrand.NewChaCha8(key).Read(buf)
+	}
+}
+
+// newWipeCopier prepares a copier running one pass over [0, size) of dst.
+func newWipeCopier(dst *os.File, size uint64, opts CopyOptions, pass wipePass) (*copier, error) {
+	// zero chunks are overwritten like any other
+	opts.ZeroPolicy = ZeroWrite
+	if pass == nil {
+		opts.ZeroPolicy = ZeroDiscard
+	}
+	opts.setDefaults()
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if err := checkDirectIO(opts, dst); err != nil {
+		return nil, err
+	}
+
+	c := newCopier(dst, size, opts)
+	if err := c.preflight(nil, 0); err != nil {
+		return nil, err
+	}
+	if pass == nil {
+		c.zero.wipe = true
+		if size > 0 {
+			c.sched.extents = []extent{{offset: 0, length: size, hole: true}}
+		}
+		return c, nil
+	}
+	c.pooled = true
+	c.fill = func(offset, count uint64, buf []byte) ([]byte, error) {
+		pass(offset, buf[:count])
+		return buf[:count], nil
+	}
+	return c, nil
+}
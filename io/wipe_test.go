@@ -0,0 +1,113 @@
+package io
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"sync"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *IOTestSuite) TestWipeZero() {
+	dstFile, _ := suite.createRandomFile(1024 * 1024)
+	defer os.Remove(dstFile.Name())
+
+	opts := WipeOptions{CopyOptions: CopyOptions{ChunkSize: 64 * 1024, Verify: true}}
+	res, err := Wipe(context.Background(), dstFile, opts)
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), uint64(1024*1024), res.BytesWritten)
+	assert.Equal(suite.T(), uint64(16), res.ZeroChunks)
+	assert.Positive(suite.T(), res.SyncTime)
+	assert.Empty(suite.T(), res.Mismatches)
+
+	data, err := os.ReadFile(dstFile.Name())
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), make([]byte, 1024*1024), data)
+}
+
+func (suite *IOTestSuite) TestWipeRandom() {
+	dstFile, old := suite.createRandomFile(1024 * 1024)
+	defer os.Remove(dstFile.Name())
+
+	opts := WipeOptions{CopyOptions: CopyOptions{ChunkSize: 64 * 1024, Verify: true}, Mode: WipeRandom}
+	res, err := Wipe(context.Background(), dstFile, opts)
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), uint64(1024*1024), res.BytesWritten)
+	assert.NotEmpty(suite.T(), res.Digest)
+
+	data, err := os.ReadFile(dstFile.Name())
+	suite.Require().NoError(err)
+	assert.NotEqual(suite.T(), old, data)
+	// chunks are keyed by their offset, no two are the same
+	assert.NotEqual(suite.T(), data[:64*1024], data[64*1024:128*1024])
+	assert.False(suite.T(), isZeroChunk(data[:64*1024]))
+}
+
+func (suite *IOTestSuite) TestWipePatterns() {
+	dstFile, _ := suite.createRandomFile(256 * 1024)
+	defer os.Remove(dstFile.Name())
+
+	var mu sync.Mutex
+	passes := map[int]int{}
+	opts := WipeOptions{
+		CopyOptions: CopyOptions{
+			ChunkSize: 4096,
+			Progress: func(p Progress) {
+				mu.Lock()
+				defer mu.Unlock()
+				passes[p.Pass] = p.Passes
+			},
+		},
+		Mode: WipePatterns,
+	}
+	res, err := Wipe(context.Background(), dstFile, opts)
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), map[int]int{1: 4, 2: 4, 3: 4, 4: 4}, passes)
+	assert.Equal(suite.T(), uint64(4*256*1024), res.BytesWritten)
+	data, err := os.ReadFile(dstFile.Name())
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), make([]byte, 256*1024), data)
+
+	// a pattern that does not divide the chunk size runs on across chunks
+	opts = WipeOptions{
+		CopyOptions: CopyOptions{ChunkSize: 4096, Verify: true},
+		Mode:        WipePatterns,
+		Patterns:    [][]byte{{0xde, 0xad, 0xbe}},
+	}
+	_, err = Wipe(context.Background(), dstFile, opts)
+	suite.Require().NoError(err)
+	data, err = os.ReadFile(dstFile.Name())
+	suite.Require().NoError(err)
+	expected := bytes.Repeat([]byte{0xde, 0xad, 0xbe}, 256*1024/3+1)[:256*1024]
+	assert.Equal(suite.T(), expected, data)
+}
+
+func (suite *IOTestSuite) TestWipeDiscardFallsBack() {
+	dstFile, _ := suite.createRandomFile(256 * 1024)
+	defer os.Remove(dstFile.Name())
+
+	// regular files are not discarded but overwritten with zeros
+	res, err := Wipe(context.Background(), dstFile, WipeOptions{Mode: WipeDiscard})
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), ZeroWrite, res.ZeroPolicy)
+	data, err := os.ReadFile(dstFile.Name())
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), make([]byte, 256*1024), data)
+}
+
+func (suite *IOTestSuite) TestWipeInvalidOptions() {
+	dstFile, _ := suite.createRandomFile(4096)
+	defer os.Remove(dstFile.Name())
+
+	for _, opts := range []WipeOptions{
+		{Mode: WipeDiscard + 1},
+		{Mode: WipeDiscard, CopyOptions: CopyOptions{Verify: true}},
+		{Mode: WipePatterns, Patterns: [][]byte{{}}},
+		{CopyOptions: CopyOptions{ZeroPolicy: ZeroPunchHole}},
+		{CopyOptions: CopyOptions{Checkpoint: dstFile.Name() + ".journal"}},
+	} {
+		_, err := Wipe(context.Background(), dstFile, opts)
+		assert.ErrorIs(suite.T(), err, ErrInvalidOptions)
+	}
+}
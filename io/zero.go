@@ -0,0 +1,180 @@
+package io
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ZeroPolicy selects how the regions where the source is all zeros are
+// handled on the destination.
+type ZeroPolicy int
+
+const (
+	// ZeroSkip leaves the destination untouched, so whatever it held
+	// before stays there. This is the default.
+	ZeroSkip ZeroPolicy = iota
+	// ZeroWrite writes zeros like any other chunk.
+	ZeroWrite
+	// ZeroPunchHole deallocates the range with
+	// fallocate(FALLOC_FL_PUNCH_HOLE).
+	ZeroPunchHole
+	// ZeroDiscard zeroes the range on block devices, discarding it with
+	// BLKDISCARD when the device guarantees discarded blocks read back as
+	// zeros and with BLKZEROOUT otherwise. On regular files it punches
+	// holes.
+	ZeroDiscard
+)
+
+func (p ZeroPolicy) String() string {
+	switch p {
+	case ZeroSkip:
+		return "skip"
+	case ZeroWrite:
+		return "write"
+	case ZeroPunchHole:
+		return "punch-hole"
+	case ZeroDiscard:
+		return "discard"
+	}
+	return "unknown"
+}
+
+// zeroer clears zero regions of the destination. When the requested policy
+// is not supported by the destination it falls back to writing zeros for the
+// rest of the run.
+type zeroer struct {
+	dst           *os.File
+	policy        ZeroPolicy
+	isDevice      bool
+	discardZeroes bool
+	// buf is a chunk of zeros, shared read-only by all workers
+	buf      []byte
+	fallback atomic.Bool
+	// wipe discards device ranges with BLKSECDISCARD, or BLKDISCARD when
+	// noSecure is set, and writes zeros when neither is supported
+	wipe     bool
+	noSecure atomic.Bool
+}
+
+func newZeroer(dst *os.File, policy ZeroPolicy, chunkSize int) *zeroer {
+	z := &zeroer{dst: dst, policy: policy, buf: make([]byte, chunkSize)}
+	if info, err := dst.Stat(); err == nil && isBlockDevice(info) {
+		z.isDevice = true
+		var zeroes uint32
+		if _, _, errno := unix.Syscall(unix.SYS_IOCTL, dst.Fd(), unix.BLKDISCARDZEROES, uintptr(unsafe.Pointer(&zeroes))); errno == 0 {
+			z.discardZeroes = zeroes != 0
+		}
+	}
+	return z
+}
+
+func (z *zeroer) zeroRange(offset, length uint64, counters *counters) error {
+	if z.policy != ZeroWrite && !z.fallback.Load() {
+		err := z.offload(offset, length)
+		if err == nil {
+			if z.wipe {
+				// discarded ranges count as done for progress reports
+				counters.holeBytes.Add(length)
+			}
+			return nil
+		}
+		if !isUnsupported(err) {
+			return newIOError(OpZero, z.dst, offset, length, err)
+		}
+		z.fallback.Store(true)
+	}
+	if _, err := PWrite(z.dst, z.buf[:length], int(length), offset); err != nil {
+		return err
+	}
+	counters.bytesWritten.Add(length)
+	return nil
+}
+
+// writesZeros reports whether zero ranges are currently cleared by writing
+// zeros.
+func (z *zeroer) writesZeros() bool {
+	return z.policy == ZeroWrite || z.fallback.Load()
+}
+
+// offload clears the range without transferring any data.
+func (z *zeroer) offload(offset, length uint64) error {
+	if z.wipe {
+		return z.discard(offset, length)
+	}
+	if z.policy == ZeroDiscard && z.isDevice {
+		op := uintptr(unix.BLKZEROOUT)
+		if z.discardZeroes {
+			op = unix.BLKDISCARD
+		}
+		r := [2]uint64{offset, length}
+		if _, _, errno := unix.Syscall(unix.SYS_IOCTL, z.dst.Fd(), op, uintptr(unsafe.Pointer(&r))); errno != 0 {
+			return errno
+		}
+		return nil
+	}
+	return unix.Fallocate(int(z.dst.Fd()), unix.FALLOC_FL_PUNCH_HOLE|unix.FALLOC_FL_KEEP_SIZE, int64(offset), int64(length))
+}
+
+// discard discards a device range, securely when the device supports it.
+// Regular files are overwritten, punching holes would leave their old
+// blocks as they are.
+func (z *zeroer) discard(offset, length uint64) error {
+	if !z.isDevice {
+		return unix.EOPNOTSUPP
+	}
+	r := [2]uint64{offset, length}
+	if !z.noSecure.Load() {
+		_, _, errno := unix.Syscall(unix.SYS_IOCTL, z.dst.Fd(), unix.BLKSECDISCARD, uintptr(unsafe.Pointer(&r)))
+		if errno == 0 || !isUnsupported(errno) {
+			return errnoErr(errno)
+		}
+		z.noSecure.Store(true)
+	}
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, z.dst.Fd(), unix.BLKDISCARD, uintptr(unsafe.Pointer(&r)))
+	return errnoErr(errno)
+}
+
+func errnoErr(errno unix.Errno) error {
+	if errno == 0 {
+		return nil
+	}
+	return errno
+}
+
+// applied reports the policy that was actually used.
+func (z *zeroer) applied() ZeroPolicy {
+	if z.fallback.Load() {
+		return ZeroWrite
+	}
+	if z.policy == ZeroDiscard && !z.isDevice {
+		return ZeroPunchHole
+	}
+	return z.policy
+}
+
+// extend grows a regular destination file to size. Punched holes keep the
+// file size, so a trailing zero region would otherwise be missing.
+func (z *zeroer) extend(size uint64) error {
+	return extendFile(z.dst, size)
+}
+
+// extendFile grows f to size if it is a regular file shorter than that.
+func extendFile(f *os.File, size uint64) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() || uint64(info.Size()) >= size {
+		return nil
+	}
+	return f.Truncate(int64(size))
+}
+
+func isUnsupported(err error) bool {
+	return errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.ENOTTY) ||
+		errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.ENODEV)
+}
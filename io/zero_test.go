@@ -0,0 +1,104 @@
+package io
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"os"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *IOTestSuite) TestCopyZeroPolicy() {
+	// 4M of random data with a zeroed chunk, a hole and a zero tail
+	data := make([]byte, 4*1024*1024) // 4M
+	_, err := rand.Read(data[:3*1024*1024])
+	suite.Require().NoError(err)
+	clear(data[64*1024 : 128*1024])
+	clear(data[1024*1024 : 2*1024*1024])
+
+	srcFile, err := os.CreateTemp("", "4M_file")
+	suite.Require().NoError(err)
+	defer os.Remove(srcFile.Name())
+	suite.Require().NoError(srcFile.Truncate(int64(len(data))))
+	_, err = srcFile.WriteAt(data[:1024*1024], 0)
+	suite.Require().NoError(err)
+	_, err = srcFile.WriteAt(data[2*1024*1024:3*1024*1024], 2*1024*1024)
+	suite.Require().NoError(err)
+
+	for policy, applied := range map[ZeroPolicy]ZeroPolicy{
+		ZeroSkip:      ZeroSkip,
+		ZeroWrite:     ZeroWrite,
+		ZeroPunchHole: ZeroPunchHole,
+		ZeroDiscard:   ZeroPunchHole,
+	} {
+		// The destination holds stale data shorter than the source
+		dstFile, err := os.CreateTemp("", "dstfile")
+		suite.Require().NoError(err)
+		stale := bytes.Repeat([]byte{0xff}, 3*1024*1024+512)
+		_, err = dstFile.WriteAt(stale, 0)
+		suite.Require().NoError(err)
+
+		res, err := CopyContext(context.Background(), srcFile, dstFile, CopyOptions{ChunkSize: 64 * 1024, ZeroPolicy: policy})
+		suite.Require().NoError(err, policy.String())
+		suite.Require().NotNil(res)
+
+		dstData, err := os.ReadFile(dstFile.Name())
+		suite.Require().NoError(err)
+		if policy == ZeroSkip {
+			// Zero regions keep the stale bytes
+			assert.Equal(suite.T(), stale[64*1024:128*1024], dstData[64*1024:128*1024])
+			assert.Equal(suite.T(), stale[1024*1024:2*1024*1024], dstData[1024*1024:2*1024*1024])
+			assert.Equal(suite.T(), data[:64*1024], dstData[:64*1024])
+		} else {
+			assert.Equal(suite.T(), data, dstData, policy.String())
+		}
+		// ext4 and most other filesystems support punching holes
+		assert.Equal(suite.T(), applied, res.ZeroPolicy, policy.String())
+
+		dstFile.Close()
+		os.Remove(dstFile.Name())
+	}
+}
+
+func (suite *IOTestSuite) TestWriteZeroPolicyWrite() {
+	dstFile, err := os.CreateTemp("", "dstfile")
+	suite.Require().NoError(err)
+	defer os.Remove(dstFile.Name())
+	_, err = dstFile.WriteAt(bytes.Repeat([]byte{0xff}, 1024*1024), 0)
+	suite.Require().NoError(err)
+
+	data := make([]byte, 1024*1024+777)
+	_, err = rand.Read(data[512*1024:])
+	suite.Require().NoError(err)
+
+	res, err := WriteContext(context.Background(), dstFile, data, uint64(len(data)), CopyOptions{ChunkSize: 4096, ZeroPolicy: ZeroWrite})
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), ZeroWrite, res.ZeroPolicy)
+
+	dstData, err := os.ReadFile(dstFile.Name())
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), data, dstData)
+}
+
+func (suite *IOTestSuite) TestCopyZeroPolicyInvalid() {
+	_, err := WriteContext(context.Background(), nil, nil, 0, CopyOptions{ChunkSize: 4096, ZeroPolicy: ZeroDiscard + 1})
+	assert.Error(suite.T(), err)
+}
+
+func (suite *IOTestSuite) TestZeroPolicyCharDevice() {
+	dst, err := os.OpenFile("/dev/null", os.O_WRONLY, 0)
+	suite.Require().NoError(err)
+	defer dst.Close()
+
+	// character devices get no block device ioctls
+	_, err = getSourceVolSize(dst)
+	assert.ErrorContains(suite.T(), err, "unsupported file type")
+	_, _, err = blockSizes(dst)
+	assert.NoError(suite.T(), err)
+
+	data := make([]byte, 64*1024)
+	res, err := WriteContext(context.Background(), dst, data, uint64(len(data)), CopyOptions{ChunkSize: 4096, ZeroPolicy: ZeroDiscard})
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), ZeroWrite, res.ZeroPolicy)
+}